@@ -3,6 +3,9 @@ package asthelper
 import (
 	"go/ast"
 	"go/token"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/cszczepaniak/go-tools/internal/file"
 )
@@ -38,17 +41,186 @@ func NodeContains(n ast.Node, pos token.Pos) bool {
 }
 
 func RangeFromNode(fset *token.FileSet, n ast.Node) file.Range {
-	pStart := fset.PositionFor(n.Pos(), false)
-	pEnd := fset.PositionFor(n.End(), false)
-
 	return file.Range{
-		Start: file.Position{
-			Line: pStart.Line,
-			Col:  pStart.Column,
-		},
-		Stop: file.Position{
-			Line: pEnd.Line,
-			Col:  pEnd.Column,
-		},
+		Start: PositionFor(fset, n.Pos()),
+		Stop:  PositionFor(fset, n.End()),
+	}
+}
+
+func PositionFor(fset *token.FileSet, pos token.Pos) file.Position {
+	p := fset.PositionFor(pos, false)
+	return file.Position{
+		Line: p.Line,
+		Col:  p.Column,
+	}
+}
+
+// ImportReplacement returns a replacement that adds the given import paths to f, or false if f
+// already imports all of them. They're added to the last import declaration; a declaration of a
+// single import without parentheses is rewritten as a group so that f doesn't end up with an import
+// declaration for each path.
+func ImportReplacement(fset *token.FileSet, f *ast.File, paths ...string) (file.Replacement, bool) {
+	var missing []string
+	for _, p := range paths {
+		if _, ok := ImportName(f, p); !ok {
+			missing = append(missing, strconv.Quote(p))
+		}
+	}
+
+	if len(missing) == 0 {
+		return file.Replacement{}, false
+	}
+
+	var last *ast.GenDecl
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			last = gd
+		}
+	}
+
+	switch {
+	case last != nil && last.Lparen.IsValid():
+		p := PositionFor(fset, last.Rparen)
+		lines := make([]string, 0, len(missing)+1)
+		for _, spec := range missing {
+			lines = append(lines, "\t"+spec)
+		}
+		return file.Replacement{
+			Range: file.Range{Start: p, Stop: p},
+			Lines: append(lines, ""),
+		}, true
+	case last != nil:
+		spec := last.Specs[0].(*ast.ImportSpec)
+		existing := spec.Path.Value
+		if spec.Name != nil {
+			existing = spec.Name.Name + " " + existing
+		}
+		if spec.Comment != nil {
+			existing += " " + spec.Comment.List[0].Text
+		}
+		return file.Replacement{
+			Range: RangeFromNode(fset, last),
+			Lines: ImportDecl(append([]string{existing}, missing...)...),
+		}, true
+	default:
+		p := PositionFor(fset, f.Name.End())
+		return file.Replacement{
+			Range: file.Range{Start: p, Stop: p},
+			Lines: append([]string{"", ""}, ImportDecl(missing...)...),
+		}, true
+	}
+}
+
+// ImportDecl returns the lines of an import declaration of specs, which are quoted import paths
+// optionally preceded by a name and followed by a comment. Like goimports, it groups the standard
+// library before the other packages, and sorts each group by path.
+func ImportDecl(specs ...string) []string {
+	if len(specs) == 1 {
+		return []string{"import " + specs[0]}
+	}
+
+	pathOf := func(spec string) string {
+		start := strings.IndexByte(spec, '"')
+		p, err := strconv.QuotedPrefix(spec[start:])
+		if err != nil {
+			return spec
+		}
+		return p
+	}
+
+	var std, other []string
+	for _, spec := range specs {
+		if strings.Contains(strings.Split(pathOf(spec), "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	byPath := func(a, b string) int { return strings.Compare(pathOf(a), pathOf(b)) }
+	slices.SortStableFunc(std, byPath)
+	slices.SortStableFunc(other, byPath)
+
+	lines := []string{"import ("}
+	for _, spec := range std {
+		lines = append(lines, "\t"+spec)
+	}
+	if len(std) > 0 && len(other) > 0 {
+		lines = append(lines, "")
+	}
+	for _, spec := range other {
+		lines = append(lines, "\t"+spec)
+	}
+	return append(lines, ")")
+}
+
+// ImportName returns the name f refers to the package with the given import path by: the name the
+// import gives it, or else the last element of path, which is the package's name for the standard
+// library. It reports false if f doesn't import path, or only imports it for its side effects.
+func ImportName(f *ast.File, path string) (string, bool) {
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || p != path {
+			continue
+		}
+		if imp.Name == nil {
+			return path[strings.LastIndex(path, "/")+1:], true
+		}
+		if imp.Name.Name != "_" {
+			return imp.Name.Name, true
+		}
+	}
+	return "", false
+}
+
+// Qualified returns how f refers to name from the package with the given import path, and reports
+// whether the package still has to be imported (see ImportReplacement) for it to work.
+func Qualified(f *ast.File, path, name string) (string, bool) {
+	pkg, ok := ImportName(f, path)
+	switch {
+	case !ok:
+		return path[strings.LastIndex(path, "/")+1:] + "." + name, true
+	case pkg == ".":
+		return name, false
+	default:
+		return pkg + "." + name, false
+	}
+}
+
+// StructField is one field of a struct type.
+type StructField struct {
+	// Decl is the declaration of the field, which it shares with the other names declared with it.
+	Decl *ast.Field
+	// Name is the name of the field, or nil if it's embedded.
+	Name *ast.Ident
+}
+
+// StructFields returns the fields of st in order, which is also the order of the fields of its
+// types.Struct: one for each name of a declaration, or one for an embedded field.
+func StructFields(st *ast.StructType) []StructField {
+	var fields []StructField
+	for _, decl := range st.Fields.List {
+		if len(decl.Names) == 0 {
+			fields = append(fields, StructField{Decl: decl})
+			continue
+		}
+		for _, n := range decl.Names {
+			fields = append(fields, StructField{Decl: decl, Name: n})
+		}
+	}
+	return fields
+}
+
+// BlockComment returns the comment c (a line or block comment) as a block comment, so that code
+// can follow it on the same line. It reports false if c is a line comment that can't be turned
+// into one because it contains "*/".
+func BlockComment(c string) (string, bool) {
+	if strings.HasPrefix(c, "/*") {
+		return c, true
+	}
+
+	text := strings.TrimPrefix(c, "//")
+	if strings.Contains(text, "*/") {
+		return "", false
 	}
+	return "/*" + text + " */", true
 }
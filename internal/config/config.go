@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProjectFileName is the name of the per-project config file. The nearest one in the directories
+// above the file being edited is used.
+const ProjectFileName = ".go-tools.json"
+
+// Config holds the user's preferences for the generators.
+type Config struct {
+	// TabWidth is the width of a tab when measuring line lengths.
+	TabWidth int `json:"tabWidth"`
+
+	SelectorChain SelectorChain `json:"selectorChain"`
+	IfErr         IfErr         `json:"ifErr"`
+	Stringer      Stringer      `json:"stringer"`
+	StructTags    StructTags    `json:"structTags"`
+	Build         Build         `json:"build"`
+	Cache         Cache         `json:"cache"`
+}
+
+type SelectorChain struct {
+	// Mode is either "all", which breaks before every selector, or "calls", which only breaks
+	// before method calls and only when the chain doesn't fit in MaxLineLength.
+	Mode string `json:"mode"`
+	// MaxLineLength is the longest a line can be before a chain is split in "calls" mode.
+	MaxLineLength int `json:"maxLineLength"`
+}
+
+type IfErr struct {
+	// Wrap returns errors wrapped with fmt.Errorf and the name of what returned them, rather than
+	// as they are.
+	Wrap bool `json:"wrap"`
+}
+
+type Stringer struct {
+	// TextMarshaling generates MarshalText and UnmarshalText methods alongside String.
+	TextMarshaling bool `json:"textMarshaling"`
+	// Parse generates a ParseX(string) (X, error) function alongside String.
+	Parse bool `json:"parse"`
+}
+
+type StructTags struct {
+	// Mode is one of "add" (add or update the tags for Keys), "remove" (strip the tags for Keys) or
+	// "align" (only realign the existing tags).
+	Mode string `json:"mode"`
+	// Keys are the tag keys to operate on, e.g. json, yaml, db or mapstructure.
+	Keys []string `json:"keys"`
+	// Case is the naming convention for tag names: snake, camel or kebab.
+	Case string `json:"case"`
+	// KeyCase overrides Case for individual keys.
+	KeyCase map[string]string `json:"keyCase"`
+	// Options are appended to the tag name for the given key, e.g. {"json": ["omitempty"]}.
+	Options map[string][]string `json:"options"`
+}
+
+// Build controls how the package of the file being edited is loaded. Tags needed by the file's own
+// build constraints are added automatically.
+type Build struct {
+	// Tags are extra build tags, e.g. integration.
+	Tags []string `json:"tags"`
+	// Flags are extra flags for the go command, e.g. -mod=mod.
+	Flags []string `json:"flags"`
+	// GOFLAGS is set as the GOFLAGS environment variable of the go command.
+	GOFLAGS string `json:"goflags"`
+	// Env holds extra environment variables for the go command, e.g. GOOS, GOARCH or GOWORK.
+	Env map[string]string `json:"env"`
+}
+
+// Cache controls the on-disk cache of type information for dependencies.
+type Cache struct {
+	// Dir is where the cache lives. It defaults to ~/.go-tools/cache.
+	Dir string `json:"dir"`
+	// Disable turns the cache off; dependencies are then loaded through the go command every time.
+	Disable bool `json:"disable"`
+	// MaxAgeDays is how many days entries are kept after they were last used. Zero keeps them
+	// forever.
+	MaxAgeDays int `json:"maxAgeDays"`
+}
+
+// Default returns the config used when no config files are present.
+func Default() Config {
+	return Config{
+		TabWidth: 4,
+		SelectorChain: SelectorChain{
+			Mode:          "all",
+			MaxLineLength: 100,
+		},
+		StructTags: StructTags{
+			Mode: "add",
+			Keys: []string{"json"},
+			Case: "snake",
+		},
+		Cache: Cache{
+			MaxAgeDays: 30,
+		},
+	}
+}
+
+// Load reads the global config at ~/.go-tools/config.json and then the nearest project config
+// above absPath. Settings in the project config override the global ones. Missing files are not an
+// error.
+func Load(absPath string) (Config, error) {
+	cfg := Default()
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Cache.Dir = filepath.Join(home, ".go-tools", "cache")
+
+	err = readInto(&cfg, filepath.Join(home, ".go-tools", "config.json"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	if p, ok := findProjectFile(filepath.Dir(absPath)); ok {
+		err = readInto(&cfg, p)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(cfg.Cache.Dir, "~/"); ok {
+		cfg.Cache.Dir = filepath.Join(home, rest)
+	}
+
+	return cfg, nil
+}
+
+func findProjectFile(dir string) (string, bool) {
+	for {
+		p := filepath.Join(dir, ProjectFileName)
+		if _, err := os.Stat(p); err == nil {
+			return p, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+func readInto(cfg *Config, path string) error {
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bs, cfg)
+}
@@ -0,0 +1,197 @@
+package file
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the table used to diff lines. Replacements that would need a
+// bigger one are only trimmed of their common leading lines.
+const maxDiffCells = 1 << 22
+
+// Minimize splits rs into the smallest set of whole-line insertions and deletions that has the
+// same effect, so that the lines they don't change aren't touched. rs must all belong to c.
+// Replacements that touch the same lines are diffed together, so the result never overlaps. The
+// placeholders and cursor of the replacements, which are positions in the file after they're
+// applied, stay with the first of the replacements they're turned into.
+func Minimize(c Contents, rs []Replacement) ([]Replacement, error) {
+	edits, err := c.edits(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rs) == 0 {
+		return nil, nil
+	}
+
+	var res []Replacement
+	for _, g := range c.lineGroups(edits) {
+		m, err := c.minimize(rs[0].AbsPath, g)
+		if err != nil {
+			return nil, err
+		}
+		if len(m) > 0 {
+			for _, e := range g.edits {
+				m[0].Placeholders = append(m[0].Placeholders, rs[e.r].Placeholders...)
+				if m[0].Cursor == nil {
+					m[0].Cursor = rs[e.r].Cursor
+				}
+			}
+		}
+		res = append(res, m...)
+	}
+	return res, nil
+}
+
+// lineGroup is a run of whole lines of c and the edits in them.
+type lineGroup struct {
+	start, end int
+	edits      []edit
+}
+
+// lineGroups widens edits, which are sorted, to whole lines and groups the ones that share lines.
+func (c Contents) lineGroups(edits []edit) []lineGroup {
+	var groups []lineGroup
+	for _, e := range edits {
+		start := bytes.LastIndexByte(c.Contents[:e.start], '\n') + 1
+		end := len(c.Contents)
+		if idx := bytes.IndexByte(c.Contents[e.end:], '\n'); idx != -1 {
+			end = e.end + idx + 1
+		}
+
+		if n := len(groups); n > 0 && start < groups[n-1].end {
+			groups[n-1].end = max(groups[n-1].end, end)
+			groups[n-1].edits = append(groups[n-1].edits, e)
+			continue
+		}
+		groups = append(groups, lineGroup{start: start, end: end, edits: []edit{e}})
+	}
+	return groups
+}
+
+func (c Contents) minimize(path string, g lineGroup) ([]Replacement, error) {
+	var after strings.Builder
+	last := g.start
+	for _, e := range g.edits {
+		after.Write(c.Contents[last:e.start])
+		after.WriteString(e.text)
+		last = e.end
+	}
+	after.Write(c.Contents[last:g.end])
+
+	before := splitLines(string(c.Contents[g.start:g.end]))
+
+	// offsets[i] is the offset in c of before[i].
+	offsets := make([]int, len(before)+1)
+	offsets[0] = g.start
+	for i, ln := range before {
+		offsets[i+1] = offsets[i] + len(ln)
+	}
+
+	afterLines := splitLines(after.String())
+	var res []Replacement
+	for _, h := range diffLines(before, afterLines) {
+		startPos, err := c.PositionOf(offsets[h.aStart], UTF8)
+		if err != nil {
+			return nil, err
+		}
+		stopPos, err := c.PositionOf(offsets[h.aEnd], UTF8)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, Replacement{
+			AbsPath: path,
+			Range:   Range{Start: startPos, Stop: stopPos},
+			Lines:   strings.Split(strings.Join(afterLines[h.bStart:h.bEnd], ""), "\n"),
+		})
+	}
+	return res, nil
+}
+
+// splitLines splits s after each newline. The last line has no newline if s doesn't end in one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunk replaces a[aStart:aEnd] with b[bStart:bEnd].
+type hunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// diffLines returns the hunks that turn a into b, in order, keeping the longest common
+// subsequence of lines. Lines are matched as early as possible, so text added after a line that
+// also ends the added text is inserted after it rather than before.
+func diffLines(a, b []string) []hunk {
+	// Common trailing lines aren't trimmed like leading ones: when code is appended after a node
+	// that ends like the appended code does (with a brace, say), they would match the node's end
+	// with the appended code's instead of its own.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+
+	a, b = a[pre:], b[pre:]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if len(a)*len(b) > maxDiffCells {
+		return []hunk{{pre, pre + len(a), pre, pre + len(b)}}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var hunks []hunk
+	var cur *hunk
+	flush := func() {
+		if cur != nil {
+			hunks = append(hunks, *cur)
+			cur = nil
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			flush()
+			i++
+			j++
+			continue
+		}
+
+		if cur == nil {
+			cur = &hunk{aStart: pre + i, aEnd: pre + i, bStart: pre + j, bEnd: pre + j}
+		}
+		if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+			cur.aEnd++
+		} else {
+			j++
+			cur.bEnd++
+		}
+	}
+	flush()
+
+	return hunks
+}
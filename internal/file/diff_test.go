@@ -0,0 +1,105 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestMinimize(t *testing.T) {
+	c := Contents{Contents: []byte(`package foo
+
+func foo() {
+	f, err := open()
+	bar()
+}
+`)}
+
+	// Re-emits the assignment with a check after it, the way iferr does.
+	r := Replacement{
+		Range: Range{Start: Position{Line: 4, Col: 2}, Stop: Position{Line: 4, Col: 18}},
+		Lines: []string{
+			"f, err := open()",
+			"\tif err != nil {",
+			"\t\treturn",
+			"\t}",
+		},
+	}
+
+	got, err := Minimize(c, []Replacement{r})
+	must.NoError(t, err)
+	must.Eq(t, []Replacement{{
+		Range: Range{Start: Position{Line: 5, Col: 1}, Stop: Position{Line: 5, Col: 1}},
+		Lines: []string{"\tif err != nil {", "\t\treturn", "\t}", ""},
+	}}, got)
+	must.Eq(t, apply(t, c, []Replacement{r}), apply(t, c, got))
+}
+
+func TestMinimize_Hunks(t *testing.T) {
+	c := Contents{Contents: []byte("a\nb\nc\nd\ne")}
+
+	r := Replacement{
+		Range: Range{Start: Position{Line: 1, Col: 1}, Stop: Position{Line: 5, Col: 2}},
+		Lines: []string{"a", "B", "c", "d", "d2", "e"},
+		// The cursor is already a position in the result, so it's carried over as it is.
+		Cursor: &Position{Line: 5, Col: 3},
+	}
+
+	got, err := Minimize(c, []Replacement{r})
+	must.NoError(t, err)
+	must.Eq(t, []Replacement{{
+		Range:  Range{Start: Position{Line: 2, Col: 1}, Stop: Position{Line: 3, Col: 1}},
+		Lines:  []string{"B", ""},
+		Cursor: &Position{Line: 5, Col: 3},
+	}, {
+		Range: Range{Start: Position{Line: 5, Col: 1}, Stop: Position{Line: 5, Col: 1}},
+		Lines: []string{"d2", ""},
+	}}, got)
+	must.Eq(t, apply(t, c, []Replacement{r}), apply(t, c, got))
+}
+
+func TestMinimize_NoChange(t *testing.T) {
+	c := Contents{Contents: []byte("a\nb\n")}
+
+	got, err := Minimize(c, []Replacement{{
+		Range: Range{Start: Position{Line: 1, Col: 1}, Stop: Position{Line: 2, Col: 2}},
+		Lines: []string{"a", "b"},
+	}})
+	must.NoError(t, err)
+	must.SliceEmpty(t, got)
+}
+
+func TestMinimize_Append(t *testing.T) {
+	c := Contents{Contents: []byte("type T struct {\n\tA int\n}\n")}
+
+	got, err := Minimize(c, []Replacement{{
+		Range: Range{Start: Position{Line: 1, Col: 1}, Stop: Position{Line: 3, Col: 2}},
+		Lines: []string{"type T struct {", "\tA int", "}", "", "func f() {", "}"},
+	}})
+	must.NoError(t, err)
+	must.Eq(t, []Replacement{{
+		Range: Range{Start: Position{Line: 4, Col: 1}, Stop: Position{Line: 4, Col: 1}},
+		Lines: []string{"", "func f() {", "}", ""},
+	}}, got)
+}
+
+func TestMinimize_SameLine(t *testing.T) {
+	c := Contents{Contents: []byte("func foo() {\n\tprintln(f() + 2)\n}\n")}
+
+	// Declares a variable before the statement and uses it in the statement, like extractvar does.
+	rs := []Replacement{{
+		Range: Range{Start: Position{Line: 2, Col: 2}, Stop: Position{Line: 2, Col: 2}},
+		Lines: []string{"i := f() + 2", "\t"},
+	}, {
+		Range: Range{Start: Position{Line: 2, Col: 10}, Stop: Position{Line: 2, Col: 17}},
+		Lines: []string{"i"},
+	}}
+
+	got, err := Minimize(c, rs)
+	must.NoError(t, err)
+	must.Eq(t, []Replacement{{
+		Range: Range{Start: Position{Line: 2, Col: 1}, Stop: Position{Line: 3, Col: 1}},
+		Lines: []string{"\ti := f() + 2", "\tprintln(i)", ""},
+	}}, got)
+	must.Eq(t, "func foo() {\n\ti := f() + 2\n\tprintln(i)\n}\n", apply(t, c, got))
+}
@@ -1,5 +1,11 @@
 package file
 
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
 type Contents struct {
 	AbsPath  string
 	Contents []byte
@@ -16,6 +22,18 @@ func (c Contents) BytesInRange(start, stop int) []byte {
 	return c.Contents[start:stop]
 }
 
+// IndentAt returns the leading whitespace of the line containing offset.
+func (c Contents) IndentAt(offset int) string {
+	offset = min(offset, len(c.Contents))
+
+	start := bytes.LastIndexByte(c.Contents[:offset], '\n') + 1
+	end := start
+	for end < len(c.Contents) && (c.Contents[end] == '\t' || c.Contents[end] == ' ') {
+		end++
+	}
+	return string(c.Contents[start:end])
+}
+
 type Position struct {
 	Line int `json:"ln"`
 	Col  int `json:"col"`
@@ -43,6 +61,63 @@ func (r Range) ContainsPos(pos Position) bool {
 }
 
 type Replacement struct {
-	Range Range    `json:"rng"`
-	Lines []string `json:"lns,omitempty"`
+	// AbsPath is the file the replacement applies to. It is empty for the file being edited.
+	AbsPath string   `json:"path,omitempty"`
+	Range   Range    `json:"rng"`
+	Lines   []string `json:"lns,omitempty"`
+
+	// Placeholders and Cursor say where the user is likely to want to type next. Generators give
+	// their positions relative to Lines, where line 1 is Lines[0]; Format turns them into
+	// positions in the file after all of its replacements are applied.
+	Placeholders []Placeholder `json:"phs,omitempty"`
+	// Cursor is where to put the cursor if there are no placeholders.
+	Cursor *Position `json:"cur,omitempty"`
+}
+
+// Placeholder is a part of a replacement for the user to fill in, like a tab stop in an LSP
+// snippet. Placeholders with the same Index hold the same text, and editors that can edit them
+// together should; the ones with lower indices come first.
+type Placeholder struct {
+	Index int   `json:"idx"`
+	Range Range `json:"rng"`
+}
+
+func (p Position) Less(other Position) bool {
+	if p.Line != other.Line {
+		return p.Line < other.Line
+	}
+	return p.Col < other.Col
+}
+
+// SortForApply sorts the replacements by file, and then from the end of the file to the start so
+// that applying them in order never invalidates the position of a replacement that comes later.
+func SortForApply(rs []Replacement) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].AbsPath != rs[j].AbsPath {
+			return rs[i].AbsPath < rs[j].AbsPath
+		}
+		return rs[j].Range.Start.Less(rs[i].Range.Start)
+	})
+}
+
+// Apply returns the contents of c with rs applied. rs must all belong to c.
+func Apply(c Contents, rs []Replacement) ([]byte, error) {
+	rs = append([]Replacement(nil), rs...)
+	SortForApply(rs)
+
+	out := append([]byte(nil), c.Contents...)
+	for _, r := range rs {
+		start, err := c.OffsetOf(r.Range.Start, UTF8)
+		if err != nil {
+			return nil, err
+		}
+		end, err := c.OffsetOf(r.Range.Stop, UTF8)
+		if err != nil {
+			return nil, err
+		}
+
+		text := strings.Join(r.Lines, "\n")
+		out = append(out[:start:start], append([]byte(text), out[end:]...)...)
+	}
+	return out, nil
 }
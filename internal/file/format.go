@@ -0,0 +1,338 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// edit is a replacement in terms of byte offsets.
+type edit struct {
+	start, end int
+	text       string
+	// r is the index of the replacement the edit comes from.
+	r int
+	// newStart and newEnd are the bounds of text after all of the edits are applied.
+	newStart, newEnd int
+}
+
+// Format applies rs, which must all belong to c, checks that the result parses, and runs gofmt on
+// the declarations that they touch. It returns replacements that replace each of those
+// declarations as a whole with its formatted version; replacements outside of any declaration are
+// returned as they are.
+//
+// If c has syntax errors of its own, the result can't be formatted, so rs are only checked to not
+// add any.
+//
+// The placeholders and cursor of rs are moved to the first replacement returned, as positions in
+// the file after all of them are applied.
+func Format(c Contents, rs []Replacement) ([]Replacement, error) {
+	if len(rs) == 0 {
+		return rs, nil
+	}
+
+	edits, err := c.edits(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	last := 0
+	for i := range edits {
+		e := &edits[i]
+		buf.Write(c.Contents[last:e.start])
+		e.newStart = buf.Len()
+		buf.WriteString(e.text)
+		e.newEnd = buf.Len()
+		last = e.end
+	}
+	buf.Write(c.Contents[last:])
+	src := buf.Bytes()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, c.AbsPath, src, parser.ParseComments)
+	if err != nil {
+		if brokenBefore(c) && !errorsIn(err, edits) {
+			res := make([]Replacement, len(rs))
+			for i, r := range rs {
+				res[i] = Replacement{AbsPath: r.AbsPath, Range: r.Range, Lines: r.Lines}
+			}
+			return res, placeMarks(res, rs, edits, src, nil)
+		}
+		return nil, fmt.Errorf("generated code does not parse: %w", err)
+	}
+
+	tokFile := fset.File(f.Package)
+	regions := declRegions(f, tokFile, edits)
+
+	path := rs[0].AbsPath
+	var res []Replacement
+	for i := range regions {
+		r := &regions[i]
+		if r.decls == 0 {
+			for _, e := range r.edits {
+				res = append(res, c.replacement(path, e.start, e.end, e.text))
+			}
+			continue
+		}
+
+		formatted, err := format.Source(src[r.start:r.end])
+		if err != nil {
+			return nil, fmt.Errorf("formatting generated code: %w", err)
+		}
+		r.src, r.formatted = src[r.start:r.end], formatted
+
+		// The region starts and ends outside of the edits, so its bounds in c are only shifted by
+		// the edits before it.
+		first, last := r.edits[0], r.edits[len(r.edits)-1]
+		start := first.start - (first.newStart - r.start)
+		end := last.end + (r.end - last.newEnd)
+		res = append(res, c.replacement(path, start, end, string(formatted)))
+	}
+
+	return res, placeMarks(res, rs, edits, src, regions)
+}
+
+// placeMarks sets the placeholders and cursor of res[0] to those of rs, moved from their positions
+// in the text of rs to the ones they end up at in the file once src is formatted by regions.
+func placeMarks(res, rs []Replacement, edits []edit, src []byte, regions []region) error {
+	marked := false
+	for _, r := range rs {
+		if len(r.Placeholders) > 0 || r.Cursor != nil {
+			marked = true
+		}
+	}
+	if !marked || len(res) == 0 {
+		return nil
+	}
+
+	// newStart[i] is where the text of rs[i] starts in src.
+	newStart := make([]int, len(rs))
+	for _, e := range edits {
+		newStart[e.r] = e.newStart
+	}
+
+	var final bytes.Buffer
+	last := 0
+	for _, r := range regions {
+		if r.formatted != nil {
+			final.Write(src[last:r.start])
+			final.Write(r.formatted)
+			last = r.end
+		}
+	}
+	final.Write(src[last:])
+	out := Contents{Contents: final.Bytes()}
+
+	place := func(i int, pos Position, after bool) (Position, error) {
+		off, err := offsetInLines(rs[i].Lines, pos)
+		if err != nil {
+			return Position{}, err
+		}
+		return out.PositionOf(mapOffset(newStart[i]+off, after, regions), UTF8)
+	}
+
+	first := &res[0]
+	for i, r := range rs {
+		for _, ph := range r.Placeholders {
+			start, err := place(i, ph.Range.Start, false)
+			if err != nil {
+				return err
+			}
+			stop, err := place(i, ph.Range.Stop, true)
+			if err != nil {
+				return err
+			}
+			first.Placeholders = append(first.Placeholders, Placeholder{
+				Index: ph.Index,
+				Range: Range{Start: start, Stop: stop},
+			})
+		}
+		if r.Cursor != nil && first.Cursor == nil {
+			pos, err := place(i, *r.Cursor, true)
+			if err != nil {
+				return err
+			}
+			first.Cursor = &pos
+		}
+	}
+	return nil
+}
+
+// offsetInLines converts pos, which is relative to lines, to an offset in the lines joined by
+// newlines.
+func offsetInLines(lines []string, pos Position) (int, error) {
+	if pos.Line < 1 || pos.Line > len(lines) || pos.Col < 1 || pos.Col > len(lines[pos.Line-1])+1 {
+		return 0, fmt.Errorf("position %d:%d is outside of the replacement", pos.Line, pos.Col)
+	}
+
+	off := 0
+	for _, l := range lines[:pos.Line-1] {
+		off += len(l) + 1
+	}
+	return off + pos.Col - 1, nil
+}
+
+// mapOffset maps offset in the edited source to the same place once regions are formatted.
+// Formatting only changes whitespace, so inside of a region the offset is found again by counting
+// the other bytes before it. If after is set, it stays right after those bytes; otherwise it moves
+// up to the next one.
+func mapOffset(offset int, after bool, regions []region) int {
+	shift := 0
+	for _, r := range regions {
+		if r.formatted == nil || offset < r.start {
+			continue
+		}
+		if offset >= r.end {
+			shift += len(r.formatted) - len(r.src)
+			continue
+		}
+
+		n := 0
+		for _, b := range r.src[:offset-r.start] {
+			if !isSpace(b) {
+				n++
+			}
+		}
+
+		i := 0
+		for ; i < len(r.formatted) && (n > 0 || !after); i++ {
+			if isSpace(r.formatted[i]) {
+				continue
+			}
+			if n == 0 {
+				break
+			}
+			n--
+			if n == 0 && after {
+				i++
+				break
+			}
+		}
+		return r.start + shift + i
+	}
+	return offset + shift
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// edits converts rs to edits sorted by offset. Insertions at the same offset are in reverse, which
+// is the order they end up in when they're applied one after another (see SortForApply).
+func (c Contents) edits(rs []Replacement) ([]edit, error) {
+	edits := make([]edit, 0, len(rs))
+	for i := len(rs) - 1; i >= 0; i-- {
+		r := rs[i]
+		start, err := c.OffsetOf(r.Range.Start, UTF8)
+		if err != nil {
+			return nil, err
+		}
+		end, err := c.OffsetOf(r.Range.Stop, UTF8)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit{start: start, end: end, text: strings.Join(r.Lines, "\n"), r: i})
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	for i := 1; i < len(edits); i++ {
+		if edits[i].start < edits[i-1].end {
+			return nil, errors.New("replacements overlap")
+		}
+	}
+	return edits, nil
+}
+
+func (c Contents) replacement(path string, start, end int, text string) Replacement {
+	// The offsets come from c, so they're always in range.
+	startPos, _ := c.PositionOf(start, UTF8)
+	endPos, _ := c.PositionOf(end, UTF8)
+	return Replacement{
+		AbsPath: path,
+		Range:   Range{Start: startPos, Stop: endPos},
+		Lines:   strings.Split(text, "\n"),
+	}
+}
+
+// region is a range of the edited source that is formatted as a whole.
+type region struct {
+	start, end int
+	edits      []edit
+	// decls is how many declarations the region covers.
+	decls int
+	// src and formatted are the region before and after formatting. formatted is nil if the region
+	// isn't formatted.
+	src, formatted []byte
+}
+
+// declRegions groups edits by the top-level declarations they touch. Each region covers those
+// declarations (with their doc comments) and its edits, and regions don't overlap.
+func declRegions(f *ast.File, tokFile *token.File, edits []edit) []region {
+	var regions []region
+	for _, e := range edits {
+		r := region{start: e.newStart, end: e.newEnd, edits: []edit{e}}
+		for _, d := range f.Decls {
+			start, end := tokFile.Offset(declStart(d)), tokFile.Offset(d.End())
+			if start <= e.newEnd && e.newStart <= end {
+				r.start = min(r.start, start)
+				r.end = max(r.end, end)
+				r.decls++
+			}
+		}
+
+		if n := len(regions); n > 0 && r.start < regions[n-1].end {
+			prev := &regions[n-1]
+			prev.start = min(prev.start, r.start)
+			prev.end = max(prev.end, r.end)
+			prev.edits = append(prev.edits, e)
+			prev.decls += r.decls
+			continue
+		}
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+func declStart(d ast.Decl) token.Pos {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	}
+	return d.Pos()
+}
+
+func brokenBefore(c Contents) bool {
+	_, err := parser.ParseFile(token.NewFileSet(), c.AbsPath, c.Contents, parser.AllErrors)
+	return err != nil
+}
+
+// errorsIn reports whether any of the syntax errors in err are in the text of edits.
+func errorsIn(err error, edits []edit) bool {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) {
+		return true
+	}
+
+	for _, e := range list {
+		for _, ed := range edits {
+			if ed.newStart <= e.Pos.Offset && e.Pos.Offset <= ed.newEnd {
+				return true
+			}
+		}
+	}
+	return false
+}
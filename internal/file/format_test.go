@@ -0,0 +1,122 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func apply(t *testing.T, c Contents, rs []Replacement) string {
+	t.Helper()
+
+	out, err := Apply(c, rs)
+	must.NoError(t, err)
+	return string(out)
+}
+
+func TestFormat(t *testing.T) {
+	c := Contents{Contents: []byte(`package foo
+
+import "fmt"
+
+type T struct {
+	Name string
+	Größe int
+}
+
+func unrelated()  {}
+`)}
+
+	rs := []Replacement{{
+		Range: Range{Start: Position{Line: 8, Col: 2}, Stop: Position{Line: 8, Col: 2}},
+		Lines: []string{
+			"",
+			"",
+			"func NewT(name string, größe int) T {",
+			"  return T{",
+			"   Name: name,",
+			"  Größe: größe,",
+			"  }",
+			"}",
+		},
+	}, {
+		Range: Range{Start: Position{Line: 3, Col: 13}, Stop: Position{Line: 3, Col: 13}},
+		Lines: []string{"", `import "strings"`},
+	}}
+
+	got, err := Format(c, rs)
+	must.NoError(t, err)
+	must.Len(t, 2, got)
+
+	must.Eq(t, `package foo
+
+import "fmt"
+import "strings"
+
+type T struct {
+	Name  string
+	Größe int
+}
+
+func NewT(name string, größe int) T {
+	return T{
+		Name:  name,
+		Größe: größe,
+	}
+}
+
+func unrelated()  {}
+`, apply(t, c, got))
+}
+
+func TestFormat_DoesNotParse(t *testing.T) {
+	c := Contents{Contents: []byte("package foo\n\nfunc foo() {\n}\n")}
+
+	_, err := Format(c, []Replacement{{
+		Range: Range{Start: Position{Line: 3, Col: 13}, Stop: Position{Line: 3, Col: 13}},
+		Lines: []string{"", "\tif x {"},
+	}})
+	must.ErrorContains(t, err, "generated code does not parse")
+}
+
+func TestFormat_BrokenElsewhere(t *testing.T) {
+	c := Contents{Contents: []byte("package foo\n\nfunc foo() {\n}\n\nfunc bar( {\n")}
+	rs := []Replacement{{
+		Range: Range{Start: Position{Line: 3, Col: 13}, Stop: Position{Line: 3, Col: 13}},
+		Lines: []string{"", "  println(1)"},
+	}}
+
+	got, err := Format(c, rs)
+	must.NoError(t, err)
+	must.Eq(t, rs, got)
+}
+
+func TestFormat_Placeholders(t *testing.T) {
+	c := Contents{Contents: []byte("package foo\n\ntype T struct{ A int }\n")}
+
+	cursor := Position{Line: 4, Col: 15}
+	got, err := Format(c, []Replacement{{
+		Range: Range{Start: Position{Line: 3, Col: 23}, Stop: Position{Line: 3, Col: 23}},
+		Lines: []string{
+			"",
+			"",
+			"func  NewT( a int ) T {",
+			"return T{A: a}",
+			"}",
+		},
+		Placeholders: []Placeholder{{
+			Index: 1,
+			Range: Range{Start: Position{Line: 3, Col: 7}, Stop: Position{Line: 3, Col: 11}},
+		}},
+		Cursor: &cursor,
+	}})
+	must.NoError(t, err)
+	must.Len(t, 1, got)
+
+	must.Eq(t, "package foo\n\ntype T struct{ A int }\n\nfunc NewT(a int) T {\n\treturn T{A: a}\n}\n", apply(t, c, got))
+	must.Eq(t, []Placeholder{{
+		Index: 1,
+		Range: Range{Start: Position{Line: 5, Col: 6}, Stop: Position{Line: 5, Col: 10}},
+	}}, got[0].Placeholders)
+	must.Eq(t, &Position{Line: 6, Col: 16}, got[0].Cursor)
+}
@@ -0,0 +1,140 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ColumnEncoding is the unit that columns are counted in. Positions in this package use UTF8, the
+// same as go/token; LSP clients default to UTF16.
+type ColumnEncoding int
+
+const (
+	// UTF8 counts bytes.
+	UTF8 ColumnEncoding = iota
+	// UTF16 counts UTF-16 code units; runes outside of the basic multilingual plane count twice.
+	UTF16
+	// UTF32 counts runes.
+	UTF32
+)
+
+// ParseColumnEncoding parses the names used by LSP: utf-8, utf-16 and utf-32.
+func ParseColumnEncoding(s string) (ColumnEncoding, error) {
+	switch s {
+	case "utf-8":
+		return UTF8, nil
+	case "utf-16":
+		return UTF16, nil
+	case "utf-32":
+		return UTF32, nil
+	default:
+		return 0, fmt.Errorf("unknown column encoding %q", s)
+	}
+}
+
+func (e ColumnEncoding) String() string {
+	switch e {
+	case UTF8:
+		return "utf-8"
+	case UTF16:
+		return "utf-16"
+	case UTF32:
+		return "utf-32"
+	default:
+		return fmt.Sprintf("ColumnEncoding(%d)", int(e))
+	}
+}
+
+func (e ColumnEncoding) width(r rune, size int) int {
+	switch e {
+	case UTF16:
+		if r >= 0x10000 {
+			return 2
+		}
+		return 1
+	case UTF32:
+		return 1
+	default:
+		return size
+	}
+}
+
+// lineStart returns the offset of the first byte of the given 1-based line.
+func (c Contents) lineStart(line int) (int, error) {
+	if line < 1 {
+		return 0, fmt.Errorf("line %d is out of range", line)
+	}
+
+	offset := 0
+	for l := 1; l < line; l++ {
+		idx := bytes.IndexByte(c.Contents[offset:], '\n')
+		if idx == -1 {
+			return 0, fmt.Errorf("line %d is out of range", line)
+		}
+		offset += idx + 1
+	}
+	return offset, nil
+}
+
+// OffsetOf returns the byte offset of the 1-based position, whose column is counted in enc. A
+// column in the middle of a character resolves to the start of that character. The column may be
+// one past the end of the line.
+func (c Contents) OffsetOf(pos Position, enc ColumnEncoding) (int, error) {
+	offset, err := c.lineStart(pos.Line)
+	if err != nil {
+		return 0, err
+	}
+
+	col := 1
+	for col < pos.Col {
+		if offset >= len(c.Contents) || c.Contents[offset] == '\n' {
+			return 0, fmt.Errorf("column %d is out of range on line %d", pos.Col, pos.Line)
+		}
+
+		r, size := utf8.DecodeRune(c.Contents[offset:])
+		w := enc.width(r, size)
+		if col+w > pos.Col {
+			break
+		}
+		col += w
+		offset += size
+	}
+
+	return offset, nil
+}
+
+// PositionOf returns the 1-based position of the byte offset, with the column counted in enc.
+func (c Contents) PositionOf(offset int, enc ColumnEncoding) (Position, error) {
+	if offset < 0 || offset > len(c.Contents) {
+		return Position{}, fmt.Errorf("offset %d is out of range", offset)
+	}
+
+	before := c.Contents[:offset]
+	start := bytes.LastIndexByte(before, '\n') + 1
+
+	col := 1
+	for rest := before[start:]; len(rest) > 0; {
+		r, size := utf8.DecodeRune(rest)
+		col += enc.width(r, size)
+		rest = rest[size:]
+	}
+
+	return Position{
+		Line: bytes.Count(before, []byte{'\n'}) + 1,
+		Col:  col,
+	}, nil
+}
+
+// ConvertPosition converts a position with a byte column to one with a column counted in enc.
+func (c Contents) ConvertPosition(pos Position, enc ColumnEncoding) (Position, error) {
+	if enc == UTF8 {
+		return pos, nil
+	}
+
+	offset, err := c.OffsetOf(pos, UTF8)
+	if err != nil {
+		return Position{}, err
+	}
+	return c.PositionOf(offset, enc)
+}
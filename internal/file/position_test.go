@@ -0,0 +1,79 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestPositions(t *testing.T) {
+	// "é" is two bytes and one UTF-16 unit; "𝄞" is four bytes and two UTF-16 units.
+	c := Contents{Contents: []byte("package p\n\nvar s = \"é𝄞x\"\n")}
+	xOffset := len("package p\n\nvar s = \"é𝄞")
+
+	tests := []struct {
+		enc  ColumnEncoding
+		want Position
+	}{{
+		enc:  UTF8,
+		want: Position{Line: 3, Col: 16},
+	}, {
+		enc:  UTF16,
+		want: Position{Line: 3, Col: 13},
+	}, {
+		enc:  UTF32,
+		want: Position{Line: 3, Col: 12},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.enc.String(), func(t *testing.T) {
+			pos, err := c.PositionOf(xOffset, tt.enc)
+			must.NoError(t, err)
+			must.Eq(t, tt.want, pos)
+
+			offset, err := c.OffsetOf(tt.want, tt.enc)
+			must.NoError(t, err)
+			must.Eq(t, xOffset, offset)
+
+			converted, err := c.ConvertPosition(Position{Line: 3, Col: 16}, tt.enc)
+			must.NoError(t, err)
+			must.Eq(t, tt.want, converted)
+		})
+	}
+}
+
+func TestOffsetOf_Edges(t *testing.T) {
+	c := Contents{Contents: []byte("ab\n𝄞\n")}
+
+	// One past the end of a line.
+	offset, err := c.OffsetOf(Position{Line: 1, Col: 3}, UTF8)
+	must.NoError(t, err)
+	must.Eq(t, 2, offset)
+
+	// The empty line after the trailing newline.
+	offset, err = c.OffsetOf(Position{Line: 3, Col: 1}, UTF16)
+	must.NoError(t, err)
+	must.Eq(t, len(c.Contents), offset)
+
+	// In the middle of a surrogate pair.
+	offset, err = c.OffsetOf(Position{Line: 2, Col: 2}, UTF16)
+	must.NoError(t, err)
+	must.Eq(t, 3, offset)
+
+	_, err = c.OffsetOf(Position{Line: 1, Col: 5}, UTF8)
+	must.ErrorContains(t, err, "column 5 is out of range")
+
+	_, err = c.OffsetOf(Position{Line: 4, Col: 1}, UTF8)
+	must.ErrorContains(t, err, "line 4 is out of range")
+}
+
+func TestParseColumnEncoding(t *testing.T) {
+	for _, enc := range []ColumnEncoding{UTF8, UTF16, UTF32} {
+		got, err := ParseColumnEncoding(enc.String())
+		must.NoError(t, err)
+		must.Eq(t, enc, got)
+	}
+
+	_, err := ParseColumnEncoding("utf-7")
+	must.Error(t, err)
+}
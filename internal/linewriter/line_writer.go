@@ -3,19 +3,81 @@ package linewriter
 import (
 	"bytes"
 	"fmt"
+	"strings"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
 )
 
 type Writer struct {
 	hasLeftover bool
 	curr        []byte
 	lns         []string
+
+	// base is the indentation of the source line the replacement starts on, and indents are the
+	// levels added to it with Indent.
+	base    string
+	indents int
+	// fromSource is set for writers made with New.
+	fromSource bool
+}
+
+// New returns a writer for a replacement that starts at offset in contents. Lines written with
+// WriteLinef are indented like the source line containing offset, plus any levels added with
+// Indent. The first line continues that source line, so it isn't indented.
+func New(contents file.Contents, offset int) *Writer {
+	return &Writer{
+		base:       contents.IndentAt(offset),
+		fromSource: true,
+	}
+}
+
+// Indentation returns what lines written with WriteLinef currently start with.
+func (lw *Writer) Indentation() string {
+	return lw.base + strings.Repeat("\t", lw.indents)
+}
+
+// Indent indents the lines written after it by one more level.
+func (lw *Writer) Indent() *Writer {
+	lw.indents++
+	return lw
 }
 
+// Dedent undoes the last Indent.
+func (lw *Writer) Dedent() *Writer {
+	lw.indents = max(lw.indents-1, 0)
+	return lw
+}
+
+// Block writes header, then whatever body writes indented by one more level, then a closing brace.
+func (lw *Writer) Block(header string, body func()) *Writer {
+	lw.WriteLinef("%s", header)
+	lw.Indent()
+	body()
+	lw.Dedent()
+	return lw.WriteLinef("}")
+}
+
+// WriteLinef writes a line, indented as described on New. Empty lines aren't indented.
 func (lw *Writer) WriteLinef(f string, args ...any) *Writer {
-	lw.lns = append(lw.lns, fmt.Sprintf(f, args...))
+	ln := fmt.Sprintf(f, args...)
+	first := lw.fromSource && len(lw.lns) == 0 && len(lw.curr) == 0
+	if ln != "" && !first {
+		ln = lw.Indentation() + ln
+	}
+	lw.lns = append(lw.lns, ln)
 	return lw
 }
 
+// Next returns where the text of the next line written with WriteLinef starts, relative to the
+// lines written so far.
+func (lw *Writer) Next() file.Position {
+	lw.Flush()
+	if lw.fromSource && len(lw.lns) == 0 {
+		return file.Position{Line: 1, Col: 1}
+	}
+	return file.Position{Line: len(lw.lns) + 1, Col: len(lw.Indentation()) + 1}
+}
+
 func (lw *Writer) Write(bs []byte) (int, error) {
 	lw.hasLeftover = false
 	for {
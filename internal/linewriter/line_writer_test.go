@@ -1,9 +1,11 @@
 package linewriter
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
+	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/shoenig/test"
 )
 
@@ -32,3 +34,26 @@ func TestLineWriter_Write(t *testing.T) {
 		"",
 	}, lns)
 }
+
+func TestLineWriter_Indent(t *testing.T) {
+	contents := file.Contents{Contents: []byte("func foo() {\n\tswitch {\n\tcase true:\n\t\tx := 1\n\t}\n}\n")}
+
+	lw := New(contents, bytes.Index(contents.Contents, []byte("x := 1")))
+	lw.WriteLinef("x := 1")
+	lw.Block("if x > 0 {", func() {
+		lw.WriteLinef("return")
+	})
+	lw.WriteLinef("")
+	lw.Indent().WriteLinef("indented").Dedent().Dedent()
+	lw.WriteLinef("base")
+
+	test.Eq(t, []string{
+		"x := 1",
+		"\t\tif x > 0 {",
+		"\t\t\treturn",
+		"\t\t}",
+		"",
+		"\t\t\tindented",
+		"\t\tbase",
+	}, lw.TakeLines())
+}
@@ -0,0 +1,290 @@
+package loader
+
+import (
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+)
+
+// buildContext is what gets passed to the go command when loading the package.
+type buildContext struct {
+	dir    string
+	flags  []string
+	env    []string
+	goos   string
+	goarch string
+}
+
+func newBuildContext(absPath string, src []byte, opts config.Build) buildContext {
+	env := map[string]string{}
+	for k, v := range opts.Env {
+		env[k] = v
+	}
+	if opts.GOFLAGS != "" {
+		env["GOFLAGS"] = opts.GOFLAGS
+	}
+
+	goos, goarch := build.Default.GOOS, build.Default.GOARCH
+	if v, ok := env["GOOS"]; ok {
+		goos = v
+	}
+	if v, ok := env["GOARCH"]; ok {
+		goarch = v
+	}
+
+	tags := append([]string(nil), opts.Tags...)
+	if expr, ok := fileConstraint(src); ok {
+		sat, ok := satisfy(expr, platform{goos: goos, goarch: goarch, tags: tags})
+		if ok {
+			goos, goarch, tags = sat.goos, sat.goarch, sat.tags
+		} else {
+			logging.WithField("constraint", expr.String()).Warn("can't satisfy the file's build constraint")
+		}
+	}
+
+	if goos != build.Default.GOOS {
+		env["GOOS"] = goos
+	}
+	if goarch != build.Default.GOARCH {
+		env["GOARCH"] = goarch
+	}
+
+	bc := buildContext{
+		dir:    moduleRoot(filepath.Dir(absPath)),
+		env:    os.Environ(),
+		goos:   goos,
+		goarch: goarch,
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		bc.env = append(bc.env, k+"="+env[k])
+	}
+
+	if len(tags) > 0 {
+		bc.flags = append(bc.flags, "-tags="+strings.Join(tags, ","))
+	}
+	bc.flags = append(bc.flags, opts.Flags...)
+
+	return bc
+}
+
+// moduleRoot returns the directory of the go.mod above dir, or dir itself if there isn't one. The go
+// command finds a go.work above it on its own.
+func moduleRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// fileConstraint returns the //go:build constraint of the file, if it has one.
+func fileConstraint(src []byte) (constraint.Expr, bool) {
+	// Only the comments before the package clause matter, and those parse even if the rest of the
+	// file doesn't.
+	f, _ := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if f == nil {
+		return nil, false
+	}
+
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+
+		for _, c := range cg.List {
+			if !constraint.IsGoBuild(c.Text) {
+				continue
+			}
+
+			expr, err := constraint.Parse(c.Text)
+			if err != nil {
+				return nil, false
+			}
+			return expr, true
+		}
+	}
+
+	return nil, false
+}
+
+// platform is a set of answers to the questions a build constraint can ask.
+type platform struct {
+	goos   string
+	goarch string
+	tags   []string
+}
+
+func (p platform) has(tag string) bool {
+	switch {
+	case knownOS[tag]:
+		return tag == p.goos
+	case tag == "unix":
+		return unixOS[p.goos]
+	case knownArch[tag]:
+		return tag == p.goarch
+	case tag == "gc", tag == "cgo":
+		return true
+	case slices.Contains(build.Default.ReleaseTags, tag):
+		return true
+	}
+	return slices.Contains(p.tags, tag)
+}
+
+// satisfy returns the platform closest to def that satisfies expr: the one that needs the fewest
+// changes of GOOS, GOARCH and extra tags.
+func satisfy(expr constraint.Expr, def platform) (platform, bool) {
+	if expr.Eval(def.has) {
+		return def, true
+	}
+
+	oses := []string{def.goos}
+	arches := []string{def.goarch}
+	var free []string
+	for _, tag := range tagsIn(expr) {
+		switch {
+		case knownOS[tag]:
+			oses = append(oses, tag)
+		case knownArch[tag]:
+			arches = append(arches, tag)
+		case tag == "unix", tag == "gc", tag == "cgo", strings.HasPrefix(tag, "go1."):
+		default:
+			if !slices.Contains(def.tags, tag) {
+				free = append(free, tag)
+			}
+		}
+	}
+
+	// Every subset of the free tags is tried, so give up on pathological constraints.
+	if len(free) > 16 {
+		return platform{}, false
+	}
+
+	best, bestCost := platform{}, -1
+	for _, goos := range oses {
+		for _, goarch := range arches {
+			for set := 0; set < 1<<len(free); set++ {
+				p := platform{goos: goos, goarch: goarch, tags: append([]string(nil), def.tags...)}
+				cost := 0
+				if goos != def.goos {
+					cost++
+				}
+				if goarch != def.goarch {
+					cost++
+				}
+				for i, tag := range free {
+					if set&(1<<i) != 0 {
+						p.tags = append(p.tags, tag)
+						cost++
+					}
+				}
+
+				if (bestCost == -1 || cost < bestCost) && expr.Eval(p.has) {
+					best, bestCost = p, cost
+				}
+			}
+		}
+	}
+
+	return best, bestCost != -1
+}
+
+func tagsIn(expr constraint.Expr) []string {
+	switch x := expr.(type) {
+	case *constraint.TagExpr:
+		return []string{x.Tag}
+	case *constraint.NotExpr:
+		return tagsIn(x.X)
+	case *constraint.AndExpr:
+		return append(tagsIn(x.X), tagsIn(x.Y)...)
+	case *constraint.OrExpr:
+		return append(tagsIn(x.X), tagsIn(x.Y)...)
+	default:
+		panic(fmt.Sprintf("unexpected constraint expression %T", expr))
+	}
+}
+
+// These mirror the lists in go/build's syslist.go, which aren't exported.
+var knownOS = map[string]bool{
+	"aix":       true,
+	"android":   true,
+	"darwin":    true,
+	"dragonfly": true,
+	"freebsd":   true,
+	"hurd":      true,
+	"illumos":   true,
+	"ios":       true,
+	"js":        true,
+	"linux":     true,
+	"nacl":      true,
+	"netbsd":    true,
+	"openbsd":   true,
+	"plan9":     true,
+	"solaris":   true,
+	"wasip1":    true,
+	"windows":   true,
+	"zos":       true,
+}
+
+var unixOS = map[string]bool{
+	"aix":       true,
+	"android":   true,
+	"darwin":    true,
+	"dragonfly": true,
+	"freebsd":   true,
+	"hurd":      true,
+	"illumos":   true,
+	"ios":       true,
+	"linux":     true,
+	"netbsd":    true,
+	"openbsd":   true,
+	"solaris":   true,
+}
+
+var knownArch = map[string]bool{
+	"386":         true,
+	"amd64":       true,
+	"amd64p32":    true,
+	"arm":         true,
+	"armbe":       true,
+	"arm64":       true,
+	"arm64be":     true,
+	"loong64":     true,
+	"mips":        true,
+	"mipsle":      true,
+	"mips64":      true,
+	"mips64le":    true,
+	"mips64p32":   true,
+	"mips64p32le": true,
+	"ppc":         true,
+	"ppc64":       true,
+	"ppc64le":     true,
+	"riscv":       true,
+	"riscv64":     true,
+	"s390":        true,
+	"s390x":       true,
+	"sparc":       true,
+	"sparc64":     true,
+	"wasm":        true,
+}
@@ -0,0 +1,70 @@
+package loader
+
+import (
+	"go/build/constraint"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestSatisfy(t *testing.T) {
+	def := platform{goos: "linux", goarch: "amd64", tags: []string{"configured"}}
+
+	tests := []struct {
+		name string
+		expr string
+		want platform
+	}{{
+		name: "already satisfied",
+		expr: "//go:build linux && configured",
+		want: def,
+	}, {
+		name: "custom tag",
+		expr: "//go:build linux && integration",
+		want: platform{goos: "linux", goarch: "amd64", tags: []string{"configured", "integration"}},
+	}, {
+		name: "other os",
+		expr: "//go:build windows",
+		want: platform{goos: "windows", goarch: "amd64", tags: []string{"configured"}},
+	}, {
+		name: "fewest changes",
+		expr: "//go:build (darwin && arm64) || (linux && arm64)",
+		want: platform{goos: "linux", goarch: "arm64", tags: []string{"configured"}},
+	}, {
+		name: "negated tag",
+		expr: "//go:build !configured || foo",
+		want: platform{goos: "linux", goarch: "amd64", tags: []string{"configured", "foo"}},
+	}, {
+		name: "unix",
+		expr: "//go:build !unix && (windows || plan9)",
+		want: platform{goos: "windows", goarch: "amd64", tags: []string{"configured"}},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := constraint.Parse(tt.expr)
+			must.NoError(t, err)
+
+			got, ok := satisfy(expr, def)
+			must.True(t, ok)
+			must.Eq(t, tt.want, got)
+		})
+	}
+}
+
+func TestSatisfy_Impossible(t *testing.T) {
+	expr, err := constraint.Parse("//go:build linux && windows")
+	must.NoError(t, err)
+
+	_, ok := satisfy(expr, platform{goos: "linux", goarch: "amd64"})
+	must.False(t, ok)
+}
+
+func TestFileConstraint(t *testing.T) {
+	expr, ok := fileConstraint([]byte("// Copyright\n\n//go:build integration\n\npackage foo\n\nfunc {\n"))
+	must.True(t, ok)
+	must.Eq(t, "integration", expr.String())
+
+	_, ok = fileConstraint([]byte("package foo\n\n//go:build integration\n"))
+	must.False(t, ok)
+}
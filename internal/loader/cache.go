@@ -0,0 +1,385 @@
+package loader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/packages"
+)
+
+// typeCache type checks the dependencies of a package from source and stores their export data on
+// disk, so later invocations can read it back instead of type checking them again. Entries are keyed
+// by a hash of the package's sources, the keys of its imports, the Go version, the go command's
+// environment and the build context, so any change to a package invalidates it and everything that
+// depends on it.
+//
+// Entries that were invalidated are never read again, so entries that haven't been used for maxAge
+// are removed. Reading an entry marks it as used by updating its modification time.
+type typeCache struct {
+	dir    string
+	maxAge time.Duration
+	fset   *token.FileSet
+	sizes  types.Sizes
+	// salt is mixed into every key.
+	salt string
+	// immutable are the directories whose files never change, so that keys only need their names.
+	immutable []string
+
+	// pkgs are the packages loaded so far, keyed by package path. It doubles as the map that
+	// gcexportdata resolves references to other packages in.
+	pkgs map[string]*types.Package
+	keys map[*packages.Package]string
+
+	hits   int
+	misses int
+}
+
+func newTypeCache(
+	dir string,
+	maxAge time.Duration,
+	fset *token.FileSet,
+	bc buildContext,
+) (*typeCache, error) {
+	sizes := types.SizesFor("gc", bc.goarch)
+	if sizes == nil {
+		sizes = types.SizesFor("gc", "amd64")
+	}
+
+	env, err := goEnv(bc, append(saltVars, immutableVars...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append([]string{runtime.Version(), bc.goos, bc.goarch}, bc.flags...)
+	for _, v := range saltVars {
+		salt = append(salt, v+"="+env[v])
+	}
+
+	var immutable []string
+	for _, v := range immutableVars {
+		if env[v] != "" {
+			immutable = append(immutable, filepath.Clean(env[v])+string(filepath.Separator))
+		}
+	}
+
+	return &typeCache{
+		dir:       dir,
+		maxAge:    maxAge,
+		fset:      fset,
+		sizes:     sizes,
+		salt:      strings.Join(salt, " "),
+		immutable: immutable,
+		pkgs:      make(map[string]*types.Package),
+		keys:      make(map[*packages.Package]string),
+	}, nil
+}
+
+var (
+	// saltVars are the go command's settings that change the files of packages or how they're type
+	// checked. They're taken from go env, which knows their defaults and the ones set with go env -w.
+	saltVars = []string{"GOVERSION", "CGO_ENABLED", "GOFLAGS", "GOEXPERIMENT"}
+	// immutableVars are the directories the go command never changes files in: the standard library
+	// of the toolchain in GOVERSION, the module cache (which has versions in its paths) and the build
+	// cache (which names files by their contents).
+	immutableVars = []string{"GOROOT", "GOMODCACHE", "GOCACHE"}
+)
+
+// goEnv returns the values of vars in the go command's environment.
+func goEnv(bc buildContext, vars ...string) (map[string]string, error) {
+	cmd := exec.Command("go", append([]string{"env"}, vars...)...)
+	cmd.Dir = bc.dir
+	cmd.Env = bc.env
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running go env: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != len(vars) {
+		return nil, fmt.Errorf("go env printed %d values for %d variables", len(lines), len(vars))
+	}
+	env := make(map[string]string, len(vars))
+	for i, v := range vars {
+		env[v] = lines[i]
+	}
+	return env, nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// importer resolves the imports of from.
+func (c *typeCache) importer(from *packages.Package) types.Importer {
+	return importerFunc(func(path string) (*types.Package, error) {
+		if path == "unsafe" {
+			return types.Unsafe, nil
+		}
+
+		dep, ok := from.Imports[path]
+		if !ok {
+			return nil, fmt.Errorf("%s does not import %s", from.ID, path)
+		}
+		return c.load(dep)
+	})
+}
+
+func (c *typeCache) load(pkg *packages.Package) (*types.Package, error) {
+	if tp, ok := c.pkgs[pkg.PkgPath]; ok && tp.Complete() {
+		return tp, nil
+	}
+
+	// Dependencies are loaded first so that the export data of this package refers to their
+	// objects instead of creating copies of them.
+	for _, path := range sortedImports(pkg) {
+		if path == "unsafe" {
+			continue
+		}
+
+		_, err := c.load(pkg.Imports[path])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := c.key(pkg)
+	if err != nil {
+		return nil, err
+	}
+	entry := filepath.Join(c.dir, key[:2], key)
+
+	tp, err := c.read(entry, pkg.PkgPath)
+	if err == nil {
+		c.hits++
+		return tp, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		logging.WithField("pkg", pkg.ID).Warn("unreadable cache entry: " + err.Error())
+	}
+
+	c.misses++
+	tp, err = c.check(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.write(entry, tp)
+	if err != nil {
+		// The cache only makes things faster; the package is fine without it.
+		logging.WithField("pkg", pkg.ID).Warn("couldn't write cache entry: " + err.Error())
+	}
+
+	return tp, nil
+}
+
+// check type checks a dependency from source. Only its exported API is needed, so function bodies
+// are skipped and type errors are ignored.
+func (c *typeCache) check(pkg *packages.Package) (*types.Package, error) {
+	files := make([]*ast.File, 0, len(pkg.CompiledGoFiles))
+	for _, name := range pkg.CompiledGoFiles {
+		f, err := parser.ParseFile(c.fset, name, nil, parser.SkipObjectResolution)
+		if f == nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	tp := types.NewPackage(pkg.PkgPath, pkg.Name)
+	cfg := &types.Config{
+		Importer:         c.importer(pkg),
+		Sizes:            c.sizes,
+		GoVersion:        goVersion(pkg),
+		IgnoreFuncBodies: true,
+		Error:            func(error) {},
+	}
+
+	err := types.NewChecker(cfg, c.fset, tp, nil).Files(files)
+	if err != nil {
+		logging.WithField("pkg", pkg.ID).Debug("dependency has type errors: " + err.Error())
+	}
+
+	c.pkgs[pkg.PkgPath] = tp
+	return tp, nil
+}
+
+func (c *typeCache) key(pkg *packages.Package) (string, error) {
+	if k, ok := c.keys[pkg]; ok {
+		return k, nil
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", c.salt, pkg.ID, goVersion(pkg))
+
+	for _, name := range pkg.CompiledGoFiles {
+		if c.isImmutable(name) {
+			fmt.Fprintf(h, "%s\n", name)
+			continue
+		}
+
+		bs, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %x\n", name, sha256.Sum256(bs))
+	}
+
+	for _, path := range sortedImports(pkg) {
+		k, err := c.key(pkg.Imports[path])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", path, k)
+	}
+
+	k := hex.EncodeToString(h.Sum(nil))
+	c.keys[pkg] = k
+	return k, nil
+}
+
+func (c *typeCache) isImmutable(name string) bool {
+	for _, dir := range c.immutable {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *typeCache) read(entry, pkgPath string) (*types.Package, error) {
+	f, err := os.Open(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tp, err := gcexportdata.Read(bufio.NewReader(f), c.fset, c.pkgs, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are read far more often than they get old, so they're only marked as used once a day.
+	now := time.Now()
+	if fi, err := f.Stat(); err == nil && now.Sub(fi.ModTime()) > pruneInterval {
+		_ = os.Chtimes(entry, now, now)
+	}
+	return tp, nil
+}
+
+func (c *typeCache) write(entry string, tp *types.Package) error {
+	err := os.MkdirAll(filepath.Dir(entry), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a concurrent invocation never reads half an entry.
+	tmp, err := os.CreateTemp(filepath.Dir(entry), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = gcexportdata.Write(w, c.fset, tp)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), entry)
+}
+
+// pruneInterval is how often the cache is pruned. The time of the last pruning is the modification
+// time of the lastPruned file in the cache.
+const (
+	pruneInterval = 24 * time.Hour
+	lastPruned    = "last-pruned"
+)
+
+// prune removes the entries that haven't been used for maxAge, if it hasn't done so in the last
+// pruneInterval. Failures are logged; the cache works the same with stale entries in it.
+func (c *typeCache) prune() {
+	if c.maxAge <= 0 {
+		return
+	}
+
+	marker := filepath.Join(c.dir, lastPruned)
+	now := time.Now()
+	if fi, err := os.Stat(marker); err == nil && now.Sub(fi.ModTime()) < pruneInterval {
+		return
+	}
+
+	// Mark the pruning first, so that concurrent invocations don't all do it.
+	err := os.WriteFile(marker, nil, 0o644)
+	if err != nil {
+		logging.Warn("couldn't mark the cache as pruned: " + err.Error())
+		return
+	}
+
+	removed := 0
+	err = filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path == marker {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if now.Sub(fi.ModTime()) > c.maxAge {
+			err = os.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Warn("couldn't prune the cache: " + err.Error())
+	}
+	logging.WithField("removed", removed).Debug("pruned the cache")
+}
+
+func sortedImports(pkg *packages.Package) []string {
+	paths := make([]string, 0, len(pkg.Imports))
+	for path := range pkg.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// goVersion returns the language version of the package's module, or the empty string (meaning the
+// latest version) for the standard library.
+func goVersion(pkg *packages.Package) string {
+	if pkg.Module == nil || pkg.Module.GoVersion == "" {
+		return ""
+	}
+
+	parts := strings.SplitN(pkg.Module.GoVersion, ".", 3)
+	return "go" + strings.Join(parts[:min(len(parts), 2)], ".")
+}
@@ -1,14 +1,26 @@
 package loader
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/config"
 	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/cszczepaniak/go-tools/internal/logging"
 	"golang.org/x/tools/go/ast/astutil"
@@ -17,10 +29,29 @@ import (
 
 type Loader struct {
 	cursorOffset int
+	// selectionEnd is the end of the selection starting at cursorOffset. It equals cursorOffset if
+	// nothing is selected.
+	selectionEnd int
 	contents     file.Contents
+	opts         Options
 
-	fileOnce func() (File, error)
-	pkgOnce  func() (*packages.Package, error)
+	// fset is shared between parsing the current file and loading its package so that the AST of
+	// the current file can be reused during type checking.
+	fset *token.FileSet
+
+	fileOnce       func() (File, error)
+	pkgOnce        func() (*packages.Package, error)
+	scopedPkgOnce  func() (*packages.Package, error)
+	fullPkgStarted atomic.Bool
+
+	cacheHits    int
+	cacheMisses  int
+	declsSeen    int
+	declsChecked int
+
+	parseDur time.Duration
+	loadDur  time.Duration
+	checkDur time.Duration
 
 	nFilesParsed        atomic.Int64
 	whenWasMyFileParsed atomic.Int64
@@ -28,17 +59,56 @@ type Loader struct {
 	totalFunctionsSeen  atomic.Int64
 }
 
+// Options control how the package of the file is loaded.
+type Options struct {
+	Build config.Build
+	// CacheDir is where export data of dependencies is cached between invocations. If it's empty,
+	// dependencies are loaded through go/packages every time, and scoped loads load the whole
+	// package.
+	CacheDir string
+	// CacheMaxAge is how long entries of the cache are kept after they were last used. If it's
+	// zero, they're kept forever.
+	CacheMaxAge time.Duration
+	// Overlay has the contents of files that differ from what's on disk, like unsaved editor
+	// buffers, by absolute path. It covers the files of the package being edited; dependencies are
+	// always read from disk. The file being edited comes from the loader's contents either way.
+	Overlay map[string][]byte
+}
+
 func New(
 	contents file.Contents,
 	cursorOffset int,
+	opts Options,
+) *Loader {
+	return NewSelection(contents, cursorOffset, cursorOffset, opts)
+}
+
+// NewSelection returns a loader for a selection from start to end. The cursor is at start.
+func NewSelection(
+	contents file.Contents,
+	start, end int,
+	opts Options,
 ) *Loader {
+	if end < start {
+		start, end = end, start
+	}
+
 	l := &Loader{
 		contents:     contents,
-		cursorOffset: cursorOffset,
+		cursorOffset: start,
+		selectionEnd: min(end, len(contents.Contents)),
+		opts:         opts,
+		fset:         token.NewFileSet(),
 	}
 
 	l.fileOnce = sync.OnceValues(l.parseFile)
-	l.pkgOnce = sync.OnceValues(l.loadPackage)
+	l.pkgOnce = sync.OnceValues(func() (*packages.Package, error) {
+		l.fullPkgStarted.Store(true)
+		return l.loadPackage(false)
+	})
+	l.scopedPkgOnce = sync.OnceValues(func() (*packages.Package, error) {
+		return l.loadPackage(true)
+	})
 	return l
 }
 
@@ -51,16 +121,23 @@ type File struct {
 	Pos token.Pos
 	// ASTPath is the path containing the node at Pos.
 	ASTPath []ast.Node
+	// Selection is set if a range of the file is selected rather than just the cursor position.
+	Selection *Selection
+	// SyntaxErrors are the errors found while parsing the file. None of them are near the cursor;
+	// otherwise ParseFile fails.
+	SyntaxErrors scanner.ErrorList
 }
 
-func (f File) IndentLevel() int {
-	indent := 0
-	for i := len(f.ASTPath) - 1; i >= 0; i-- {
-		if _, ok := f.ASTPath[i].(*ast.BlockStmt); ok {
-			indent++
-		}
-	}
-	return indent
+// Selection is a selected range of the file.
+type Selection struct {
+	// Start and End are the bounds of the selection, without any surrounding whitespace.
+	Start, End token.Pos
+	// Path is the path to the innermost node containing the whole selection.
+	Path []ast.Node
+	// Nodes are the nodes that are fully covered by the selection: the selected node itself if the
+	// selection matches one exactly, and otherwise the children of Path[0] that lie in the selection,
+	// in source order. For example, selecting a few lines of a block gives its statements.
+	Nodes []ast.Node
 }
 
 func (l *Loader) ParseFile() (File, error) {
@@ -68,29 +145,138 @@ func (l *Loader) ParseFile() (File, error) {
 }
 
 func (l *Loader) parseFile() (File, error) {
-	fset := token.NewFileSet()
+	defer trace.StartRegion(context.Background(), "parse").End()
+	t0 := time.Now()
+	defer func() { l.parseDur += time.Since(t0) }()
+
+	// The file is usually being edited, so it's expected to have syntax errors. The parser still
+	// gives us a partial AST, which is good enough as long as the code around the cursor is intact.
 	f, err := parser.ParseFile(
-		fset,
+		l.fset,
 		l.contents.AbsPath,
 		l.contents.Contents,
 		parser.AllErrors|parser.ParseComments,
 	)
-	if err != nil {
+	if f == nil || !f.Package.IsValid() {
+		// Without a package clause there's nothing to work with.
 		return File{}, err
 	}
 
-	tokFile := fset.File(f.Pos())
+	var syntaxErrs scanner.ErrorList
+	if err != nil && !errors.As(err, &syntaxErrs) {
+		return File{}, err
+	}
+
+	tokFile := l.fset.File(f.Pos())
 	pos := tokFile.Pos(l.cursorOffset)
 	astPath, _ := astutil.PathEnclosingInterval(f, pos, pos)
 
+	var sel *Selection
+	if l.selectionEnd > l.cursorOffset {
+		sel = selectionOf(f, tokFile, l.contents, l.cursorOffset, l.selectionEnd)
+	}
+
+	if len(syntaxErrs) > 0 {
+		start, stop := cursorRegion(tokFile, astPath, l.contents, l.cursorOffset)
+		if sel != nil {
+			start = min(start, tokFile.Offset(sel.Start))
+			stop = max(stop, tokFile.Offset(sel.End))
+		}
+
+		for _, e := range syntaxErrs {
+			if start <= e.Pos.Offset && e.Pos.Offset <= stop {
+				return File{}, fmt.Errorf("syntax error near the cursor: %w", e)
+			}
+		}
+
+		logging.WithField("nErrors", len(syntaxErrs)).Debug("ignoring syntax errors away from the cursor")
+	}
+
 	return File{
-		File:    f,
-		Fset:    fset,
-		ASTPath: astPath,
-		Pos:     pos,
+		File:         f,
+		Fset:         l.fset,
+		ASTPath:      astPath,
+		Pos:          pos,
+		Selection:    sel,
+		SyntaxErrors: syntaxErrs,
 	}, nil
 }
 
+func selectionOf(f *ast.File, tokFile *token.File, contents file.Contents, start, end int) *Selection {
+	// Selections made in an editor tend to include the indentation and the trailing newline.
+	src := contents.Contents
+	for start < end && isSpace(src[start]) {
+		start++
+	}
+	for end > start && isSpace(src[end-1]) {
+		end--
+	}
+
+	sel := &Selection{
+		Start: tokFile.Pos(start),
+		End:   tokFile.Pos(end),
+	}
+
+	path, exact := astutil.PathEnclosingInterval(f, sel.Start, sel.End)
+	sel.Path = path
+	if len(path) == 0 {
+		return sel
+	}
+
+	if exact && path[0].Pos() == sel.Start && path[0].End() == sel.End {
+		sel.Nodes = []ast.Node{path[0]}
+		return sel
+	}
+
+	ast.Inspect(path[0], func(n ast.Node) bool {
+		if n == nil || n == path[0] {
+			return n != nil
+		}
+		if _, ok := n.(*ast.CommentGroup); ok {
+			return false
+		}
+
+		if sel.Start <= n.Pos() && n.End() <= sel.End {
+			sel.Nodes = append(sel.Nodes, n)
+		}
+		return false
+	})
+
+	return sel
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// cursorRegion returns the offsets of the code that has to parse for the suggestors to work on the
+// cursor: the innermost statement containing it, or the declaration if it isn't in a function body.
+// Between declarations, it's the cursor's line.
+func cursorRegion(
+	tokFile *token.File,
+	path []ast.Node,
+	contents file.Contents,
+	offset int,
+) (int, int) {
+	for _, n := range path {
+		switch n.(type) {
+		case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+			// These contain the rest of the body; look for something narrower.
+			continue
+		case ast.Stmt, ast.Decl:
+			return tokFile.Offset(n.Pos()), tokFile.Offset(n.End())
+		}
+	}
+
+	src := contents.Contents
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	stop := len(src)
+	if idx := bytes.IndexByte(src[offset:], '\n'); idx != -1 {
+		stop = offset + idx
+	}
+	return start, stop
+}
+
 func (l *Loader) parseFileForLoadPkg(
 	fset *token.FileSet,
 	filepath string,
@@ -116,9 +302,11 @@ func (l *Loader) parseFileForLoadPkg(
 			src,
 			parser.AllErrors|parser.ParseComments,
 		)
-	}
-	if err != nil {
-		return nil, err
+		if f == nil {
+			return nil, err
+		}
+		// Keep the partial AST of files with syntax errors. The errors end up in the package's
+		// Errors, but type checking still gets to see everything that did parse.
 	}
 
 	for _, decl := range f.Decls {
@@ -135,35 +323,275 @@ func (l *Loader) parseFileForLoadPkg(
 		}
 	}
 
-	return f, nil
+	return f, err
+}
+
+// readFile reads a file of the package, preferring its contents in the overlay.
+func (l *Loader) readFile(name string) ([]byte, error) {
+	if src, ok := l.opts.Overlay[name]; ok {
+		return src, nil
+	}
+	return os.ReadFile(name)
 }
 
 func (l *Loader) LoadPackage() (*packages.Package, error) {
 	return l.pkgOnce()
 }
 
-func (l *Loader) loadPackage() (*packages.Package, error) {
+// LoadScopedPackage is like LoadPackage, but only type checks the package-level declaration under
+// the cursor and the declarations it depends on. Type information for the rest of the package is
+// missing. If the whole package has already been loaded, that's returned instead, and so is the
+// whole package if there's no cache: the dependencies would have to be type checked from source,
+// which takes longer than loading all of the package through go/packages.
+func (l *Loader) LoadScopedPackage() (*packages.Package, error) {
+	if l.fullPkgStarted.Load() || l.opts.CacheDir == "" {
+		return l.pkgOnce()
+	}
+	return l.scopedPkgOnce()
+}
+
+func (l *Loader) loadPackage(scoped bool) (*packages.Package, error) {
+	bc := newBuildContext(l.contents.AbsPath, l.contents.Contents, l.opts.Build)
+	logging.WithFields(map[string]any{
+		"dir":   bc.dir,
+		"flags": bc.flags,
+	}).Debug("loading package")
+
+	mode := packages.NeedName |
+		packages.NeedFiles |
+		packages.NeedCompiledGoFiles |
+		packages.NeedTypes |
+		packages.NeedTypesInfo
+	// With the cache, dependencies come from it, so we type check everything ourselves. That's also
+	// how scoped loads leave out declarations.
+	checkOurselves := l.opts.CacheDir != ""
+	if checkOurselves {
+		// We type check everything ourselves, so we only need the import graph.
+		mode = packages.NeedName |
+			packages.NeedFiles |
+			packages.NeedCompiledGoFiles |
+			packages.NeedImports |
+			packages.NeedDeps |
+			packages.NeedModule
+	}
+
+	region := trace.StartRegion(context.Background(), "packages.Load")
+	t0 := time.Now()
 	pkgs, err := packages.Load(
 		&packages.Config{
-			Mode:      packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo,
+			Mode:       mode,
+			Fset:       l.fset,
+			Dir:        bc.dir,
+			Env:        bc.env,
+			BuildFlags: bc.flags,
+			Overlay:    l.opts.Overlay,
+			// Tests are needed to load _test.go files; otherwise they don't belong to any package.
+			// Other files are in the plain package, so their test variants aren't worth loading.
+			Tests:     strings.HasSuffix(l.contents.AbsPath, "_test.go"),
 			ParseFile: l.parseFileForLoadPkg,
 		},
 		fmt.Sprintf("file=%s", l.contents.AbsPath),
 	)
+	l.loadDur += time.Since(t0)
+	region.End()
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := l.selectPackage(pkgs)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pkgs) != 1 {
-		panic(`should be unreachable; we only specify one package in the given pattern`)
+	if checkOurselves {
+		c, err := newTypeCache(l.opts.CacheDir, l.opts.CacheMaxAge, l.fset, bc)
+		if err != nil {
+			return nil, err
+		}
+		l.checkPackage(pkg, c, scoped)
+		c.prune()
 	}
 
+	stats := l.Stats()
 	logging.WithFields(map[string]any{
-		"nFiles":    l.nFilesParsed.Load(),
-		"myFileIdx": l.whenWasMyFileParsed.Load(),
-		"nFuncs":    l.totalFunctionsSeen.Load(),
-		"nStripped": l.nFunctionsStripped.Load(),
+		"pkg":         pkg.ID,
+		"nPkgs":       len(pkgs),
+		"scoped":      scoped,
+		"nFiles":      stats.FilesParsed,
+		"myFileIdx":   l.whenWasMyFileParsed.Load(),
+		"nFuncs":      stats.FuncsSeen,
+		"nStripped":   stats.FuncsStripped,
+		"nDecls":      stats.DeclsSeen,
+		"nDeclsTyped": stats.DeclsChecked,
+		"cacheHits":   stats.CacheHits,
+		"cacheMisses": stats.CacheMisses,
 	}).Debug("package load stats")
 
-	return pkgs[0], nil
+	return pkg, nil
+}
+
+// checkPackage type checks pkg the same way go/packages would, but with its dependencies coming from
+// the cache. If scoped is set, only the declaration under the cursor and its dependencies are
+// checked.
+func (l *Loader) checkPackage(pkg *packages.Package, c *typeCache, scoped bool) {
+	defer trace.StartRegion(context.Background(), "typecheck").End()
+	t0 := time.Now()
+	defer func() { l.checkDur += time.Since(t0) }()
+
+	var files []*ast.File
+	for _, name := range pkg.CompiledGoFiles {
+		src, err := l.readFile(name)
+		if err != nil {
+			pkg.Errors = append(pkg.Errors, packages.Error{Msg: err.Error(), Kind: packages.ListError})
+			continue
+		}
+
+		f, err := l.parseFileForLoadPkg(l.fset, name, src)
+		if err != nil {
+			pkg.Errors = append(pkg.Errors, packages.Error{Msg: err.Error(), Kind: packages.ParseError})
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+
+	if scoped {
+		pos := token.NoPos
+		if f, err := l.ParseFile(); err == nil {
+			pos = f.Pos
+		}
+		files, l.declsSeen, l.declsChecked = scopeDecls(files, pos)
+	}
+
+	pkg.Fset = l.fset
+	pkg.Syntax = files
+	pkg.TypesSizes = c.sizes
+	pkg.Types = types.NewPackage(pkg.PkgPath, pkg.Name)
+	pkg.TypesInfo = &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Instances:  make(map[*ast.Ident]types.Instance),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+
+	cfg := &types.Config{
+		Importer:  c.importer(pkg),
+		Sizes:     c.sizes,
+		GoVersion: goVersion(pkg),
+		Error: func(err error) {
+			var te types.Error
+			if scoped && errors.As(err, &te) && te.Soft {
+				// Leaving out declarations leaves imports and variables unused.
+				return
+			}
+			pkg.Errors = append(pkg.Errors, typeError(err))
+		},
+	}
+
+	_ = types.NewChecker(cfg, l.fset, pkg.Types, pkg.TypesInfo).Files(files)
+	pkg.IllTyped = len(pkg.Errors) > 0
+
+	l.cacheHits, l.cacheMisses = c.hits, c.misses
+}
+
+func typeError(err error) packages.Error {
+	var te types.Error
+	if !errors.As(err, &te) {
+		return packages.Error{Msg: err.Error(), Kind: packages.TypeError}
+	}
+
+	return packages.Error{
+		Pos:  te.Fset.Position(te.Pos).String(),
+		Msg:  te.Msg,
+		Kind: packages.TypeError,
+	}
+}
+
+// Stats describes how much work loading the package took, and how much of it was avoided.
+type Stats struct {
+	// FilesParsed is the number of files of the package that were parsed.
+	FilesParsed int
+	// FuncsSeen and FuncsStripped are the number of function declarations in the package and how
+	// many of them had their bodies left out of type checking.
+	FuncsSeen     int
+	FuncsStripped int
+	// DeclsSeen and DeclsChecked are the number of package-level declarations and how many of them
+	// were type checked. They're only set for scoped loads.
+	DeclsSeen    int
+	DeclsChecked int
+	// CacheHits and CacheMisses are the number of dependencies that were read from the type cache
+	// and that had to be type checked from source.
+	CacheHits   int
+	CacheMisses int
+
+	// Parse is the time spent parsing the current file.
+	Parse time.Duration
+	// Load is the time spent in packages.Load. Unless the package is type checked by the loader
+	// itself (with the cache or a scoped load), type checking happens in there too.
+	Load time.Duration
+	// TypeCheck is the time the loader spent type checking the package and its dependencies.
+	TypeCheck time.Duration
+}
+
+// Stats returns the stats of the package load so far.
+func (l *Loader) Stats() Stats {
+	return Stats{
+		FilesParsed:   int(l.nFilesParsed.Load()),
+		FuncsSeen:     int(l.totalFunctionsSeen.Load()),
+		FuncsStripped: int(l.nFunctionsStripped.Load()),
+		DeclsSeen:     l.declsSeen,
+		DeclsChecked:  l.declsChecked,
+		CacheHits:     l.cacheHits,
+		CacheMisses:   l.cacheMisses,
+		Parse:         l.parseDur,
+		Load:          l.loadDur,
+		TypeCheck:     l.checkDur,
+	}
+}
+
+// selectPackage picks the package that the current file is compiled into. A non-test file shows up
+// both in its package and in the package's test variant; the plain package is preferred.
+func (l *Loader) selectPackage(pkgs []*packages.Package) (*packages.Package, error) {
+	var candidates []*packages.Package
+	for _, pkg := range pkgs {
+		if containsFile(pkg.CompiledGoFiles, l.contents.AbsPath) {
+			candidates = append(candidates, pkg)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no package contains %s", l.contents.AbsPath)
+	}
+
+	for _, pkg := range candidates {
+		// Test variants have IDs like "example.com/foo [example.com/foo.test]".
+		if !strings.Contains(pkg.ID, " [") {
+			return pkg, nil
+		}
+	}
+	return candidates[0], nil
+}
+
+func containsFile(files []string, path string) bool {
+	var info fs.FileInfo
+	for _, f := range files {
+		if filepath.Clean(f) == filepath.Clean(path) {
+			return true
+		}
+
+		if info == nil {
+			var err error
+			info, err = os.Stat(path)
+			if err != nil {
+				continue
+			}
+		}
+		if fInfo, err := os.Stat(f); err == nil && os.SameFile(info, fInfo) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,438 @@
+package loader_test
+
+import (
+	"bytes"
+	"go/ast"
+	"go/types"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+
+	"github.com/cszczepaniak/go-tools/internal/loader"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test/must"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestParseFile_SyntaxErrorAwayFromCursor(t *testing.T) {
+	l, _, _ := suggestiontest.Load(t, `package main
+
+func foo() int {
+	return <|>1
+}
+
+func bar() {
+	x :=
+}
+`, nil)
+
+	f, err := l.ParseFile()
+	must.NoError(t, err)
+	must.SliceNotEmpty(t, f.SyntaxErrors)
+
+	_, ok := f.ASTPath[0].(*ast.BasicLit)
+	must.True(t, ok)
+}
+
+func TestParseFile_SyntaxErrorNearCursor(t *testing.T) {
+	l, _, _ := suggestiontest.Load(t, `package main
+
+func foo() int {
+	x := <|>
+	return 1
+}
+`, nil)
+
+	_, err := l.ParseFile()
+	must.ErrorContains(t, err, "syntax error near the cursor")
+}
+
+func TestLoadPackage_SyntaxErrorsElsewhere(t *testing.T) {
+	l, _, _ := suggestiontest.Load(t, `package main
+
+func foo() int {
+	return <|>helper()
+}
+
+func bar() {
+	x :=
+}
+`, map[string]string{
+		"other.go": `package main
+
+func helper() int { return 1 }
+
+func broken() {
+	if {
+}
+`,
+	})
+
+	pkg, err := l.LoadPackage()
+	must.NoError(t, err)
+	must.SliceNotEmpty(t, pkg.Errors)
+
+	obj := pkg.Types.Scope().Lookup("helper")
+	must.NotNil(t, obj)
+
+	_, ok := obj.(*types.Func)
+	must.True(t, ok)
+}
+
+func TestLoadPackage_SelectsPackageOfFile(t *testing.T) {
+	extra := map[string]string{
+		"main.go": "package main\n\nfunc helper() int { return 1 }\n",
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		src     string
+		wantPkg string
+		wantID  string
+	}{{
+		name:    "non-test file",
+		file:    "other.go",
+		src:     "package main\n\nfunc <|>foo() {}\n",
+		wantPkg: "main",
+		wantID:  "example.com/test",
+	}, {
+		name:    "internal test file",
+		file:    "main_test.go",
+		src:     "package main\n\nimport \"testing\"\n\nfunc <|>TestFoo(t *testing.T) { helper() }\n",
+		wantPkg: "main",
+		wantID:  "example.com/test [example.com/test.test]",
+	}, {
+		name:    "external test file",
+		file:    "main_test.go",
+		src:     "package main_test\n\nimport \"testing\"\n\nfunc <|>TestFoo(t *testing.T) {}\n",
+		wantPkg: "main_test",
+		wantID:  "example.com/test_test [example.com/test.test]",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _, _ := suggestiontest.LoadAt(t, tt.file, tt.src, extra)
+
+			pkg, err := l.LoadPackage()
+			must.NoError(t, err)
+			must.Eq(t, tt.wantPkg, pkg.Name)
+			must.Eq(t, tt.wantID, pkg.ID)
+			must.NotNil(t, pkg.TypesInfo)
+		})
+	}
+}
+
+func TestLoadPackage_BuildConstraints(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+	}{{
+		name:       "custom tag",
+		constraint: "//go:build integration",
+	}, {
+		name:       "other os",
+		constraint: "//go:build windows && !linux",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _, _ := suggestiontest.LoadAt(t, "sub/tagged.go", tt.constraint+`
+
+package sub
+
+func <|>foo() int { return bar() }
+`, map[string]string{
+				"sub/bar.go": "package sub\n\nfunc bar() int { return 1 }\n",
+			})
+
+			pkg, err := l.LoadPackage()
+			must.NoError(t, err)
+			must.Eq(t, "example.com/test/sub", pkg.PkgPath)
+			must.NotNil(t, pkg.Types.Scope().Lookup("foo"))
+		})
+	}
+}
+
+func TestLoadPackage_Cache(t *testing.T) {
+	logging.InitLogger(io.Discard)
+
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+
+	src := []byte(`package main
+
+import (
+	"errors"
+
+	"example.com/test/dep"
+)
+
+var errFoo = errors.New("foo")
+
+func foo() dep.T { return dep.New() }
+`)
+	depSrc := "package dep\n\ntype T struct{ N int }\n\nfunc New() T { return T{} }\n"
+
+	mainPath := filepath.Join(dir, "main.go")
+	must.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/test\n\ngo 1.21\n"), 0o644))
+	must.NoError(t, os.MkdirAll(filepath.Join(dir, "dep"), 0o755))
+	must.NoError(t, os.WriteFile(mainPath, src, 0o644))
+
+	load := func(t *testing.T, depSrc string) (*packages.Package, int, int) {
+		must.NoError(t, os.WriteFile(filepath.Join(dir, "dep", "dep.go"), []byte(depSrc), 0o644))
+
+		l := loader.New(
+			file.Contents{AbsPath: mainPath, Contents: src},
+			bytes.Index(src, []byte("foo()")),
+			loader.Options{CacheDir: cacheDir},
+		)
+
+		pkg, err := l.LoadPackage()
+		must.NoError(t, err)
+		must.SliceEmpty(t, pkg.Errors)
+
+		stats := l.Stats()
+		return pkg, stats.CacheHits, stats.CacheMisses
+	}
+
+	pkg, hits, misses := load(t, depSrc)
+	must.Zero(t, hits)
+	must.Positive(t, misses)
+
+	fn, ok := pkg.Types.Scope().Lookup("foo").(*types.Func)
+	must.True(t, ok)
+	must.Eq(t, "func() example.com/test/dep.T", fn.Type().String())
+
+	_, hits, misses = load(t, depSrc)
+	must.Positive(t, hits)
+	must.Zero(t, misses)
+
+	// Changing a dependency invalidates it, but nothing else.
+	pkg, hits, misses = load(t, depSrc+"\nfunc Other() {}\n")
+	must.Positive(t, hits)
+	must.Eq(t, 1, misses)
+
+	dep := pkg.Types.Imports()[1]
+	must.Eq(t, "example.com/test/dep", dep.Path())
+	must.NotNil(t, dep.Scope().Lookup("Other"))
+}
+
+func TestLoadPackage_CacheGoEnv(t *testing.T) {
+	cacheDir := t.TempDir()
+	load := func(t *testing.T, goflags string) loader.Stats {
+		l, _, _ := suggestiontest.LoadWithOptions(
+			t,
+			"main.go",
+			"package main\n\nimport \"errors\"\n\nvar <|>errFoo = errors.New(\"foo\")\n",
+			nil,
+			loader.Options{CacheDir: cacheDir, Build: config.Build{GOFLAGS: goflags}},
+		)
+
+		_, err := l.LoadPackage()
+		must.NoError(t, err)
+		return l.Stats()
+	}
+
+	stats := load(t, "")
+	must.Positive(t, stats.CacheMisses)
+
+	stats = load(t, "")
+	must.Positive(t, stats.CacheHits)
+	must.Zero(t, stats.CacheMisses)
+
+	// Other settings for the go command can change the files of a package, even in the standard
+	// library.
+	stats = load(t, "-tags=other")
+	must.Zero(t, stats.CacheHits)
+	must.Positive(t, stats.CacheMisses)
+}
+
+func TestLoadPackage_CachePruning(t *testing.T) {
+	cacheDir := t.TempDir()
+	load := func(t *testing.T) loader.Stats {
+		l, _, _ := suggestiontest.LoadWithOptions(
+			t,
+			"main.go",
+			"package main\n\nimport \"errors\"\n\nvar <|>errFoo = errors.New(\"foo\")\n",
+			nil,
+			loader.Options{CacheDir: cacheDir, CacheMaxAge: time.Hour},
+		)
+
+		_, err := l.LoadPackage()
+		must.NoError(t, err)
+		return l.Stats()
+	}
+
+	stats := load(t)
+	must.Positive(t, stats.CacheMisses)
+
+	// Everything in the cache was last used long ago, but the entries that are read again are kept.
+	stale := filepath.Join(cacheDir, "ab", "stale")
+	must.NoError(t, os.MkdirAll(filepath.Dir(stale), 0o755))
+	must.NoError(t, os.WriteFile(stale, nil, 0o644))
+	old := time.Now().Add(-48 * time.Hour)
+	must.NoError(t, filepath.WalkDir(cacheDir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	}))
+
+	stats = load(t)
+	must.Positive(t, stats.CacheHits)
+	must.Zero(t, stats.CacheMisses)
+
+	_, err := os.Stat(stale)
+	must.ErrorIs(t, err, fs.ErrNotExist)
+
+	stats = load(t)
+	must.Positive(t, stats.CacheHits)
+	must.Zero(t, stats.CacheMisses)
+}
+
+func TestLoadScopedPackage(t *testing.T) {
+	l, _, _ := suggestiontest.LoadWithOptions(t, "main.go", `package main
+
+import "strings"
+
+type Shape interface{ Area() float64 }
+
+type Square struct{ Side Length }
+
+func (s Square) Area() float64 { return float64(s.Side * s.Side) }
+
+func <|>describe(s Square) string {
+	return strings.Repeat("x", int(s.Area()))
+}
+
+func unrelated() Other { return Other{} }
+`, map[string]string{
+		"other.go": "package main\n\ntype Length int\n\ntype Other struct{}\n\nvar Unused = 3\n",
+	}, loader.Options{CacheDir: t.TempDir()})
+
+	pkg, err := l.LoadScopedPackage()
+	must.NoError(t, err)
+	must.SliceEmpty(t, pkg.Errors, must.Sprint(pkg.Errors))
+
+	scope := pkg.Types.Scope()
+	for _, name := range []string{"describe", "Square", "Length"} {
+		must.NotNil(t, scope.Lookup(name), must.Sprint(name))
+	}
+	for _, name := range []string{"Shape", "unrelated", "Other", "Unused"} {
+		must.Nil(t, scope.Lookup(name), must.Sprint(name))
+	}
+
+	sq := scope.Lookup("Square").Type().(*types.Named)
+	must.Eq(t, 1, sq.NumMethods())
+
+	stats := l.Stats()
+	must.Eq(t, 8, stats.DeclsSeen)
+	must.Eq(t, 4, stats.DeclsChecked)
+}
+
+func TestLoadScopedPackage_NoCache(t *testing.T) {
+	l, _, _ := suggestiontest.Load(t, `package main
+
+func <|>foo() int { return 1 }
+
+func unrelated() {}
+`, nil)
+
+	// Without the cache, the whole package is loaded through go/packages.
+	pkg, err := l.LoadScopedPackage()
+	must.NoError(t, err)
+	must.NotNil(t, pkg.Types.Scope().Lookup("unrelated"))
+	must.Zero(t, l.Stats().DeclsSeen)
+}
+
+func TestLoadPackage_AfterScopedLoad(t *testing.T) {
+	l, _, _ := suggestiontest.LoadWithOptions(t, "main.go", `package main
+
+func <|>foo() int { return 1 }
+
+func unrelated() {}
+`, nil, loader.Options{CacheDir: t.TempDir()})
+
+	_, err := l.LoadScopedPackage()
+	must.NoError(t, err)
+	scoped := l.Stats()
+
+	t0 := time.Now()
+	pkg, err := l.LoadPackage()
+	dur := time.Since(t0)
+	must.NoError(t, err)
+	must.NotNil(t, pkg.Types.Scope().Lookup("unrelated"))
+
+	// The time of both loads is counted, so the time of the full one is the difference.
+	full := l.Stats()
+	must.GreaterEq(t, scoped.Load, full.Load)
+	must.GreaterEq(t, scoped.TypeCheck, full.TypeCheck)
+	must.LessEq(t, dur, full.Load+full.TypeCheck-scoped.Load-scoped.TypeCheck)
+}
+
+func TestParseFile_Selection(t *testing.T) {
+	src := []byte(`package main
+
+func foo() int {
+	a := 1
+	b := 2
+	c := a + b
+	return c
+}
+`)
+
+	tests := []struct {
+		name      string
+		selection string
+		want      []string
+	}{{
+		name:      "whole lines",
+		selection: "\ta := 1\n\tb := 2\n",
+		want:      []string{"a := 1", "b := 2"},
+	}, {
+		name:      "partial statement",
+		selection: "b := 2\n\tc := a",
+		want:      []string{"b := 2"},
+	}, {
+		name:      "expression",
+		selection: "a + b",
+		want:      []string{"a + b"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := bytes.Index(src, []byte(tt.selection))
+			must.NonNegative(t, start)
+
+			l := loader.NewSelection(
+				file.Contents{AbsPath: "/tmp/main.go", Contents: src},
+				start,
+				start+len(tt.selection),
+				loader.Options{},
+			)
+
+			f, err := l.ParseFile()
+			must.NoError(t, err)
+			must.NotNil(t, f.Selection)
+
+			var got []string
+			for _, n := range f.Selection.Nodes {
+				got = append(got, string(src[f.Fset.Position(n.Pos()).Offset:f.Fset.Position(n.End()).Offset]))
+			}
+			must.Eq(t, tt.want, got)
+		})
+	}
+
+	l := loader.New(file.Contents{AbsPath: "/tmp/main.go", Contents: src}, 10, loader.Options{})
+	f, err := l.ParseFile()
+	must.NoError(t, err)
+	must.Nil(t, f.Selection)
+}
@@ -0,0 +1,156 @@
+package loader
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// declNode is a package-level declaration along with the names it declares and refers to. Methods
+// are attached to their receiver's type name instead of declaring a name of their own.
+type declNode struct {
+	decl     ast.Decl
+	declares []string
+	recv     string
+	refs     []string
+}
+
+// scopeDecls returns copies of files that only contain the declaration at pos, the package-level
+// declarations it refers to (transitively), and the imports. The methods of every type that's kept
+// are kept too, since method sets decide which interfaces a type implements. If pos isn't in a
+// declaration, files are returned as they are.
+func scopeDecls(files []*ast.File, pos token.Pos) ([]*ast.File, int, int) {
+	var nodes []*declNode
+	byName := make(map[string][]*declNode)
+	methods := make(map[string][]*declNode)
+	var root *declNode
+
+	for _, f := range files {
+		for _, d := range f.Decls {
+			n := newDeclNode(d)
+			if n == nil {
+				continue
+			}
+			nodes = append(nodes, n)
+
+			if n.recv != "" {
+				methods[n.recv] = append(methods[n.recv], n)
+			}
+			for _, name := range n.declares {
+				byName[name] = append(byName[name], n)
+			}
+
+			if d.Pos() <= pos && pos <= d.End() {
+				root = n
+			}
+		}
+	}
+
+	if root == nil {
+		return files, len(nodes), len(nodes)
+	}
+
+	needed := make(map[*declNode]bool)
+	seenNames := make(map[string]bool)
+	queue := []*declNode{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if needed[n] {
+			continue
+		}
+		needed[n] = true
+
+		names := append(append([]string{n.recv}, n.refs...), n.declares...)
+		for _, name := range names {
+			if name == "" || seenNames[name] {
+				continue
+			}
+			seenNames[name] = true
+
+			queue = append(queue, byName[name]...)
+			queue = append(queue, methods[name]...)
+		}
+	}
+
+	keep := make(map[ast.Decl]bool, len(needed))
+	for n := range needed {
+		keep[n.decl] = true
+	}
+
+	scoped := make([]*ast.File, 0, len(files))
+	for _, f := range files {
+		cp := *f
+		cp.Decls = nil
+		for _, d := range f.Decls {
+			gd, ok := d.(*ast.GenDecl)
+			if keep[d] || (ok && gd.Tok == token.IMPORT) {
+				cp.Decls = append(cp.Decls, d)
+			}
+		}
+		scoped = append(scoped, &cp)
+	}
+
+	return scoped, len(nodes), len(needed)
+}
+
+func newDeclNode(d ast.Decl) *declNode {
+	n := &declNode{decl: d}
+
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			n.recv = recvTypeName(d.Recv.List[0].Type)
+		} else if d.Name.Name != "init" && d.Name.Name != "_" {
+			n.declares = append(n.declares, d.Name.Name)
+		}
+	case *ast.GenDecl:
+		if d.Tok == token.IMPORT {
+			return nil
+		}
+
+		for _, spec := range d.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				n.declares = append(n.declares, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					n.declares = append(n.declares, name.Name)
+				}
+			}
+		}
+	default:
+		return nil
+	}
+
+	// This over-approximates: field names, selectors and locals count as references too, which
+	// only means that a few more declarations are kept than strictly necessary.
+	seen := make(map[string]bool)
+	ast.Inspect(d, func(x ast.Node) bool {
+		if id, ok := x.(*ast.Ident); ok && !seen[id.Name] {
+			seen[id.Name] = true
+			n.refs = append(n.refs, id.Name)
+		}
+		return true
+	})
+
+	return n
+}
+
+func recvTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	// codeInvalidRequest is what LSP servers answer requests with after shutdown.
+	codeInvalidRequest = -32600
+	// codeRequestCancelled is what LSP servers answer requests with after $/cancelRequest.
+	codeRequestCancelled = -32800
+)
+
+// message is a JSON-RPC 2.0 request, notification or response. Requests have an ID and a method,
+// notifications only a method and responses only an ID.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+func (m message) isRequest() bool {
+	return m.ID != nil && m.Method != ""
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// conn reads and writes messages framed with the Content-Length headers of the base protocol.
+// Writes can come from several goroutines.
+type conn struct {
+	r *textproto.Reader
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+// read returns the next message. It returns io.EOF once the other side is done.
+func (c *conn) read() (message, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return message{}, io.EOF
+		}
+		return message{}, fmt.Errorf("reading header: %w", err)
+	}
+
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return message{}, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, n)
+	_, err = io.ReadFull(c.r.R, body)
+	if err != nil {
+		return message{}, fmt.Errorf("reading body: %w", err)
+	}
+
+	var m message
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return message{}, &rpcError{Code: codeParseError, Message: err.Error()}
+	}
+	return m, nil
+}
+
+func (c *conn) write(m message) error {
+	m.JSONRPC = "2.0"
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// reply writes the response to the request with the given ID. result is ignored if err is set.
+func (c *conn) reply(id json.RawMessage, result any, err error) error {
+	if err != nil {
+		rerr, ok := err.(*rpcError)
+		if !ok {
+			rerr = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		return c.write(message{ID: id, Error: rerr})
+	}
+
+	bs, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.write(message{ID: id, Result: bs})
+}
@@ -0,0 +1,165 @@
+package lsp
+
+import "encoding/json"
+
+// The parts of the Language Server Protocol that the server uses. Lines and characters are
+// 0-based, and characters are counted in the position encoding agreed on in initialize.
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// VersionedTextDocumentIdentifier identifies a version of a document. Version is nil for
+// documents that aren't open.
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type InitializeParams struct {
+	Capabilities ClientCapabilities `json:"capabilities"`
+}
+
+type ClientCapabilities struct {
+	General struct {
+		// PositionEncodings are the encodings the client supports, in order of preference.
+		PositionEncodings []string `json:"positionEncodings"`
+	} `json:"general"`
+	TextDocument struct {
+		CodeAction struct {
+			ResolveSupport struct {
+				// Properties are the properties of code actions that the client can have
+				// codeAction/resolve fill in.
+				Properties []string `json:"properties"`
+			} `json:"resolveSupport"`
+		} `json:"codeAction"`
+	} `json:"textDocument"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   ServerInfo         `json:"serverInfo"`
+}
+
+type ServerCapabilities struct {
+	PositionEncoding   string                  `json:"positionEncoding"`
+	TextDocumentSync   TextDocumentSyncOptions `json:"textDocumentSync"`
+	CodeActionProvider CodeActionOptions       `json:"codeActionProvider"`
+}
+
+type ServerInfo struct {
+	Name string `json:"name"`
+}
+
+// syncFull is the TextDocumentSyncKind where every change sends the whole document.
+const syncFull = 1
+
+type TextDocumentSyncOptions struct {
+	OpenClose bool `json:"openClose"`
+	Change    int  `json:"change"`
+}
+
+type CodeActionOptions struct {
+	CodeActionKinds []string `json:"codeActionKinds"`
+	ResolveProvider bool     `json:"resolveProvider,omitempty"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+// TextDocumentContentChangeEvent is a change to a document. Range is nil if Text is the whole
+// document, which is always the case with full sync.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type CancelParams struct {
+	// ID is the ID of the request to cancel, a number or a string.
+	ID json.RawMessage `json:"id"`
+}
+
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+}
+
+type CodeActionContext struct {
+	// Only is the kinds of actions the client asked for. If it's empty, any kind is fine.
+	Only []string `json:"only,omitempty"`
+}
+
+// codeActionKind is the kind of every action the server offers.
+const codeActionKind = "refactor.rewrite"
+
+type CodeAction struct {
+	Title string         `json:"title"`
+	Kind  string         `json:"kind"`
+	Edit  *WorkspaceEdit `json:"edit,omitempty"`
+	// Data is kept by the client and sent back with codeAction/resolve.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// codeActionData is the data of the actions whose edit is left to codeAction/resolve. Suggestor
+// tells them apart from the actions of gopls when they go through the proxy.
+type codeActionData struct {
+	Suggestor string `json:"goToolsSuggestor"`
+	URI       string `json:"uri"`
+	Range     Range  `json:"range"`
+}
+
+// WorkspaceEdit is a set of edits to apply together. Edits that create files need
+// DocumentChanges; otherwise Changes is used, since more clients support it.
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []any                 `json:"documentChanges,omitempty"`
+}
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type TextDocumentEdit struct {
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                      `json:"edits"`
+}
+
+type CreateFile struct {
+	// Kind is always "create".
+	Kind    string            `json:"kind"`
+	URI     string            `json:"uri"`
+	Options CreateFileOptions `json:"options"`
+}
+
+type CreateFileOptions struct {
+	IgnoreIfExists bool `json:"ignoreIfExists"`
+}
@@ -0,0 +1,428 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+)
+
+// proxy forwards messages between an editor and gopls. It keeps track of the documents the editor
+// opens the same way the server does, so that it can answer code action requests too.
+type proxy struct {
+	// server talks to the editor.
+	server *server
+	gopls  *conn
+
+	mu sync.Mutex
+	// pending are the requests from the editor whose responses from gopls need changing, by ID.
+	pending map[string]pendingRequest
+
+	// exiting is set once the editor has sent exit, after which gopls is expected to exit.
+	exiting atomic.Bool
+
+	// checking is held while our code actions are worked out, so that requests that pile up don't
+	// type check the package all at once. The ones cancelled while they wait are skipped.
+	checking sync.Mutex
+	// checks are the code actions being worked out, which Proxy waits for before it returns.
+	checks sync.WaitGroup
+}
+
+type pendingRequest struct {
+	method string
+	// actions receives our code actions for a textDocument/codeAction request.
+	actions chan []CodeAction
+	// ctx is cancelled once the editor cancels the request.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Proxy sits between an editor that writes to r and reads from w, and the gopls that cmd starts.
+// Everything is forwarded both ways as it is, except that the code actions of the suggestors are
+// added to the ones gopls answers textDocument/codeAction with. It returns once the editor has
+// sent exit and gopls has exited, or as soon as gopls exits on its own.
+func Proxy(r io.Reader, w io.Writer, cmd *exec.Cmd) error {
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("starting gopls: %w", err)
+	}
+
+	p := &proxy{
+		server:  newServer(newConn(r, w)),
+		gopls:   newConn(out, in),
+		pending: make(map[string]pendingRequest),
+	}
+
+	editorDone := make(chan error, 1)
+	goplsDone := make(chan error, 1)
+	go func() { editorDone <- p.fromEditor() }()
+	go func() { goplsDone <- p.fromGopls() }()
+	defer p.checks.Wait()
+
+	select {
+	case err := <-goplsDone:
+		if p.exiting.Load() {
+			// gopls was quicker to act on exit than fromEditor was to return.
+			return errors.Join(err, <-editorDone, cmd.Wait())
+		}
+		// The editor can't be stopped from waiting for its next message, but there's nothing left
+		// to send it to.
+		return errors.Join(err, cmd.Wait(), errors.New("gopls exited"))
+	case err = <-editorDone:
+	}
+
+	// gopls exits after exit, or once its input is closed if the editor went away without it.
+	in.Close()
+	return errors.Join(err, <-goplsDone, cmd.Wait())
+}
+
+// fromEditor forwards messages from the editor to gopls until the editor sends exit.
+func (p *proxy) fromEditor() error {
+	for {
+		m, err := p.server.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		var rerr *rpcError
+		if errors.As(err, &rerr) {
+			logging.WithError(err).Warn("skipping invalid message from the editor")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case m.Method == "initialize":
+			params, err := decode[InitializeParams](m.Params)
+			if err == nil {
+				p.server.setLazy(resolvesEdits(params.Capabilities))
+			}
+			p.expect(m.ID, pendingRequest{method: m.Method})
+		case m.Method == "textDocument/codeAction":
+			ctx, cancel := context.WithCancel(context.Background())
+			p.expect(m.ID, pendingRequest{
+				method:  m.Method,
+				actions: p.codeActions(ctx, m),
+				ctx:     ctx,
+				cancel:  cancel,
+			})
+		case m.Method == "codeAction/resolve":
+			a, err := decode[CodeAction](m.Params)
+			if err != nil {
+				break
+			}
+			if _, ours := actionData(a); ours {
+				// gopls doesn't know our actions.
+				p.resolve(m.ID, a)
+				continue
+			}
+		case m.Method == "$/cancelRequest":
+			params, err := decode[CancelParams](m.Params)
+			if err != nil {
+				logging.WithError(err).Warn("invalid cancellation")
+				break
+			}
+			p.cancel(params.ID)
+		case !m.isRequest() && m.Method != "":
+			err := p.server.notification(m)
+			if err != nil {
+				logging.WithError(err).WithField("method", m.Method).Warn("handling notification")
+			}
+		}
+
+		if m.Method == "exit" {
+			p.exiting.Store(true)
+		}
+		err = p.gopls.write(m)
+		if err != nil {
+			return fmt.Errorf("writing to gopls: %w", err)
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+	}
+}
+
+// fromGopls forwards messages from gopls to the editor until gopls exits.
+func (p *proxy) fromGopls() error {
+	for {
+		m, err := p.gopls.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading from gopls: %w", err)
+		}
+
+		req, ok := p.take(m)
+		switch {
+		case ok && req.method == "initialize":
+			m = p.initialized(m)
+		case ok && req.method == "textDocument/codeAction":
+			// Our actions may still be on their way; other messages don't have to wait for them.
+			go func() {
+				defer p.finish(m.ID)
+				err := p.server.conn.write(answer(req, m))
+				if err != nil {
+					logging.WithError(err).Error("writing to the editor")
+				}
+			}()
+			continue
+		}
+
+		err = p.server.conn.write(m)
+		if err != nil {
+			return fmt.Errorf("writing to the editor: %w", err)
+		}
+	}
+}
+
+func (p *proxy) expect(id json.RawMessage, req pendingRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[string(id)] = req
+}
+
+// cancel cancels the pending request with the given ID, if there is one.
+func (p *proxy) cancel(id json.RawMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req, ok := p.pending[string(id)]; ok && req.cancel != nil {
+		req.cancel()
+	}
+}
+
+// take returns the request that m responds to if its response needs changing. Requests that can be
+// cancelled stay pending until they're finished, since the editor can still cancel them while
+// their response waits for our actions.
+func (p *proxy) take(m message) (pendingRequest, bool) {
+	if m.ID == nil || m.Method != "" {
+		return pendingRequest{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.pending[string(m.ID)]
+	if req.cancel == nil {
+		delete(p.pending, string(m.ID))
+	}
+	return req, ok
+}
+
+// finish forgets the request with the given ID once it's been answered.
+func (p *proxy) finish(id json.RawMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req, ok := p.pending[string(id)]; ok {
+		req.cancel()
+		delete(p.pending, string(id))
+	}
+}
+
+// codeActions starts working out our code actions for the request m, while gopls works out its
+// own. Failures are logged, and leave gopls's actions as they are. Nothing is worked out if ctx is
+// cancelled before it's m's turn.
+func (p *proxy) codeActions(ctx context.Context, m message) chan []CodeAction {
+	res := make(chan []CodeAction, 1)
+
+	params, err := decode[CodeActionParams](m.Params)
+	if err != nil {
+		logging.WithError(err).Warn("invalid code action request")
+		res <- nil
+		return res
+	}
+
+	v := p.server.currentView()
+	p.checks.Add(1)
+	go func() {
+		defer p.checks.Done()
+		p.checking.Lock()
+		defer p.checking.Unlock()
+		if ctx.Err() != nil {
+			res <- nil
+			return
+		}
+
+		actions, err := v.codeActions(params)
+		if err != nil {
+			logging.WithError(err).Warn("generating code actions")
+		}
+		res <- actions
+	}()
+	return res
+}
+
+// resolve fills in the edit of our code action a and answers the editor's request with the given
+// ID with it.
+func (p *proxy) resolve(id json.RawMessage, a CodeAction) {
+	v := p.server.currentView()
+	p.checks.Add(1)
+	go func() {
+		defer p.checks.Done()
+		p.checking.Lock()
+		defer p.checking.Unlock()
+
+		res, err := v.resolve(a)
+		err = p.server.conn.reply(id, res, err)
+		if err != nil {
+			logging.WithError(err).Error("writing to the editor")
+		}
+	}()
+}
+
+// initialized picks up the position encoding gopls agreed on from its response to initialize, and
+// makes sure that the capabilities it returns let the editor ask for our code actions.
+func (p *proxy) initialized(m message) message {
+	if m.Error != nil {
+		return m
+	}
+
+	res, err := p.adjustCapabilities(m.Result)
+	if err != nil {
+		logging.WithError(err).Warn("reading the capabilities of gopls")
+		return m
+	}
+	m.Result = res
+	return m
+}
+
+func (p *proxy) adjustCapabilities(result json.RawMessage) (json.RawMessage, error) {
+	var res map[string]json.RawMessage
+	err := json.Unmarshal(result, &res)
+	if err != nil {
+		return nil, err
+	}
+	var caps map[string]json.RawMessage
+	err = json.Unmarshal(res["capabilities"], &caps)
+	if err != nil {
+		return nil, err
+	}
+
+	// Servers that don't say use UTF-16.
+	enc := file.UTF16
+	var name string
+	if json.Unmarshal(caps["positionEncoding"], &name) == nil {
+		if e, err := file.ParseColumnEncoding(name); err == nil {
+			enc = e
+		}
+	}
+	p.server.setEncoding(enc)
+
+	provider, err := withActionKind(caps["codeActionProvider"], p.server.currentView().lazy)
+	if err != nil {
+		return nil, err
+	}
+	caps["codeActionProvider"] = provider
+
+	res["capabilities"], err = json.Marshal(caps)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(res)
+}
+
+// withActionKind returns a codeActionProvider capability that includes codeActionKind, and that
+// offers codeAction/resolve if resolve is set.
+func withActionKind(provider json.RawMessage, resolve bool) (json.RawMessage, error) {
+	var opts map[string]json.RawMessage
+	if json.Unmarshal(provider, &opts) != nil || opts == nil {
+		// It's a boolean or missing. true allows any kind, and so do options without kinds.
+		if !resolve {
+			return json.RawMessage("true"), nil
+		}
+		opts = make(map[string]json.RawMessage)
+	}
+	if resolve {
+		opts["resolveProvider"] = json.RawMessage("true")
+	}
+
+	if raw, ok := opts["codeActionKinds"]; ok {
+		var kinds []string
+		err := json.Unmarshal(raw, &kinds)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(kinds, codeActionKind) {
+			opts["codeActionKinds"], err = json.Marshal(append(kinds, codeActionKind))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(opts)
+}
+
+// answer returns the response to send the editor for the code action request req, given the
+// response m from gopls. It waits for our actions unless the editor cancels the request, in which
+// case gopls's response is sent as it is.
+func answer(req pendingRequest, m message) message {
+	if m.Error != nil && m.Error.Code == codeRequestCancelled {
+		return m
+	}
+
+	select {
+	case ours := <-req.actions:
+		if req.ctx.Err() != nil {
+			return m
+		}
+		return withActions(m, ours)
+	case <-req.ctx.Done():
+		return m
+	}
+}
+
+// withActions adds ours to the actions in the response m. If gopls failed, ours are returned on
+// their own.
+func withActions(m message, ours []CodeAction) message {
+	if len(ours) == 0 {
+		return m
+	}
+
+	var actions []json.RawMessage
+	if m.Error != nil {
+		logging.WithError(m.Error).Warn("gopls failed to return code actions")
+		m.Error = nil
+	} else if err := json.Unmarshal(m.Result, &actions); err != nil {
+		logging.WithError(err).Warn("reading the code actions of gopls")
+		return m
+	}
+
+	for _, a := range ours {
+		bs, err := json.Marshal(a)
+		if err != nil {
+			logging.WithError(err).Warn("encoding a code action")
+			return m
+		}
+		actions = append(actions, bs)
+	}
+
+	res, err := json.Marshal(actions)
+	if err != nil {
+		logging.WithError(err).Warn("encoding code actions")
+		return m
+	}
+	m.Result = res
+	return m
+}
@@ -0,0 +1,274 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+// fakeGoplsEnv makes the test binary act as gopls instead of running the tests.
+const fakeGoplsEnv = "GO_TOOLS_FAKE_GOPLS"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeGoplsEnv) == "1" {
+		err := fakeGopls(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeGopls answers just enough to tell what the proxy passes on. It logs a message when it's
+// initialized, asks the editor for its configuration, and answers code action requests with one
+// action that says how many documents are open and what the configuration was. Code action
+// requests with string IDs aren't answered until they're cancelled.
+func fakeGopls(r io.Reader, w io.Writer) error {
+	c := newConn(r, w)
+	open := 0
+	var cfg json.RawMessage
+	held := make(map[string]bool)
+
+	for {
+		m, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		switch m.Method {
+		case "initialize":
+			err = c.write(message{
+				Method: "window/logMessage",
+				Params: json.RawMessage(`{"type":3,"message":"fake gopls"}`),
+			})
+			if err != nil {
+				return err
+			}
+			err = c.reply(m.ID, map[string]any{
+				"capabilities": map[string]any{
+					"positionEncoding": "utf-16",
+					"textDocumentSync": 2,
+					"codeActionProvider": map[string]any{
+						"codeActionKinds": []string{"quickfix"},
+					},
+				},
+			}, nil)
+		case "initialized":
+			err = c.write(message{
+				ID:     json.RawMessage(`"cfg"`),
+				Method: "workspace/configuration",
+				Params: json.RawMessage(`{"items":[{"section":"gopls"}]}`),
+			})
+		case "textDocument/didOpen":
+			open++
+		case "textDocument/didClose":
+			open--
+		case "textDocument/codeAction":
+			if strings.HasPrefix(string(m.ID), `"`) {
+				held[string(m.ID)] = true
+				break
+			}
+			err = c.reply(m.ID, []CodeAction{{
+				Title: fmt.Sprintf("gopls: %d open, config %s", open, cfg),
+				Kind:  "quickfix",
+			}}, nil)
+		case "$/cancelRequest":
+			var p CancelParams
+			err = json.Unmarshal(m.Params, &p)
+			if err == nil && held[string(p.ID)] {
+				delete(held, string(p.ID))
+				err = c.reply(p.ID, nil, &rpcError{Code: codeRequestCancelled, Message: "cancelled"})
+			}
+		case "shutdown":
+			err = c.reply(m.ID, nil, nil)
+		case "exit":
+			return nil
+		case "":
+			if string(m.ID) == `"cfg"` {
+				cfg = m.Result
+			}
+		default:
+			if m.isRequest() {
+				err = c.reply(m.ID, nil, &rpcError{Code: codeMethodNotFound, Message: m.Method})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func startProxy(t *testing.T) *client {
+	t.Helper()
+
+	exe, err := os.Executable()
+	must.NoError(t, err)
+
+	return startWith(t, func(r io.Reader, w io.Writer) error {
+		cmd := exec.Command(exe)
+		cmd.Env = append(os.Environ(), fakeGoplsEnv+"=1")
+		return Proxy(r, w, cmd)
+	})
+}
+
+func TestProxy(t *testing.T) {
+	dir := writeModule(t, map[string]string{"main.go": "package foo\n"})
+	uri := uriOf(filepath.Join(dir, "main.go"))
+
+	c := startProxy(t)
+
+	var init struct {
+		Capabilities struct {
+			PositionEncoding   string            `json:"positionEncoding"`
+			TextDocumentSync   int               `json:"textDocumentSync"`
+			CodeActionProvider CodeActionOptions `json:"codeActionProvider"`
+		} `json:"capabilities"`
+	}
+	c.call("initialize", InitializeParams{}, &init)
+	test.Eq(t, []string{"window/logMessage"}, c.notifications)
+	// gopls's capabilities are kept, but our actions are allowed too.
+	test.Eq(t, "utf-16", init.Capabilities.PositionEncoding)
+	test.Eq(t, 2, init.Capabilities.TextDocumentSync)
+	test.Eq(t, []string{"quickfix", "refactor.rewrite"}, init.Capabilities.CodeActionProvider.CodeActionKinds)
+
+	// Requests from gopls reach the editor, and the editor's responses reach gopls.
+	c.notify("initialized", struct{}{})
+	c.answer("workspace/configuration", []string{"from editor"})
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI:        uri,
+		LanguageID: "go",
+		Version:    1,
+		Text:       "package foo\n",
+	}})
+
+	// gopls asked for incremental sync, so the rest of the file comes as an insertion.
+	rest := `
+import "os"
+
+func foo() error {
+	f, err := os.Open("x")
+	_ = f
+	return nil
+}
+`
+	end := Position{Line: 1, Character: 0}
+	version := 2
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: &version},
+		ContentChanges: []TextDocumentContentChangeEvent{{Range: &Range{Start: end, End: end}, Text: rest}},
+	})
+
+	var actions []CodeAction
+	cursor := Position{Line: 5, Character: 5}
+	c.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+	}, &actions)
+
+	must.SliceNotEmpty(t, actions)
+	test.Eq(t, `gopls: 1 open, config ["from editor"]`, actions[0].Title)
+	a := actionTitled(t, actions, "Add error check")
+	test.StrContains(t, applyEdits(t, "package foo\n"+rest, a.Edit.Changes[uri]), "if err != nil {\n\t\treturn err\n")
+
+	c.exit()
+}
+
+func TestProxy_Resolve(t *testing.T) {
+	src := `package foo
+
+import "os"
+
+func foo() error {
+	f, err := os.Open("x")
+	_ = f
+	return nil
+}
+`
+	dir := writeModule(t, map[string]string{"main.go": src})
+	uri := uriOf(filepath.Join(dir, "main.go"))
+
+	c := startProxy(t)
+
+	var init struct {
+		Capabilities struct {
+			CodeActionProvider CodeActionOptions `json:"codeActionProvider"`
+		} `json:"capabilities"`
+	}
+	c.call("initialize", resolvingClient, &init)
+	test.True(t, init.Capabilities.CodeActionProvider.ResolveProvider)
+	c.notify("initialized", struct{}{})
+	c.answer("workspace/configuration", []string{})
+
+	var actions []CodeAction
+	cursor := Position{Line: 5, Character: 5}
+	c.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+	}, &actions)
+	a := actionTitled(t, actions, "Add error check")
+	test.Nil(t, a.Edit)
+
+	// gopls would answer that it doesn't know the method, so the proxy must answer itself.
+	var resolved CodeAction
+	c.call("codeAction/resolve", a, &resolved)
+	must.NotNil(t, resolved.Edit)
+	test.StrContains(t, applyEdits(t, src, resolved.Edit.Changes[uri]), "if err != nil {\n\t\treturn err\n")
+
+	c.exit()
+}
+
+func TestProxy_Cancel(t *testing.T) {
+	src := `package foo
+
+import "os"
+
+func foo() error {
+	f, err := os.Open("x")
+	_ = f
+	return nil
+}
+`
+	dir := writeModule(t, map[string]string{"main.go": src})
+	uri := uriOf(filepath.Join(dir, "main.go"))
+
+	c := startProxy(t)
+	c.call("initialize", InitializeParams{}, nil)
+	c.notify("initialized", struct{}{})
+	c.answer("workspace/configuration", []string{})
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI:        uri,
+		LanguageID: "go",
+		Version:    1,
+		Text:       src,
+	}})
+
+	cursor := Position{Line: 5, Character: 5}
+	params, err := json.Marshal(CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+	})
+	must.NoError(t, err)
+	id := json.RawMessage(`"held"`)
+	must.NoError(t, c.conn.write(message{ID: id, Method: "textDocument/codeAction", Params: params}))
+	c.notify("$/cancelRequest", CancelParams{ID: id})
+
+	// gopls's answer to the cancelled request reaches the editor without our actions.
+	m := c.next()
+	test.Eq(t, string(id), string(m.ID))
+	must.NotNil(t, m.Error)
+	test.Eq(t, codeRequestCancelled, m.Error.Code)
+	test.Nil(t, m.Result)
+
+	c.exit()
+}
@@ -0,0 +1,553 @@
+// Package lsp offers the suggestors to editors as code actions over the Language Server Protocol.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cszczepaniak/go-tools/internal"
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+)
+
+// titles are the titles of the code actions of each suggestor.
+var titles = map[string]string{
+	"constructor":   "Generate constructor",
+	"exhaustive":    "Fill in missing cases",
+	"extractfunc":   "Extract function",
+	"extractvar":    "Extract variable",
+	"iferr":         "Add error check",
+	"selectorchain": "Split or join selector chain",
+	"stringer":      "Generate String method",
+	"structtags":    "Update struct tags",
+	"tabletest":     "Generate table test",
+	"wrap":          "Split or join list",
+}
+
+type server struct {
+	conn     *conn
+	shutdown bool
+
+	// mu guards view. When the proxy runs, code actions are computed on their own goroutines while
+	// documents keep changing, so views are never modified; changes replace them instead.
+	mu   sync.Mutex
+	view *view
+}
+
+// view is the state that code actions are computed from.
+type view struct {
+	enc file.ColumnEncoding
+	// lazy is set if the client fills in the edits of code actions with codeAction/resolve, so that
+	// they're only generated for the action that's picked.
+	lazy bool
+	// docs are the open documents by absolute path.
+	docs map[string]document
+}
+
+type document struct {
+	version int
+	text    []byte
+}
+
+func newServer(c *conn) *server {
+	return &server{
+		conn: c,
+		view: &view{enc: file.UTF16, docs: make(map[string]document)},
+	}
+}
+
+func (s *server) currentView() *view {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.view
+}
+
+// update replaces the view with a copy that fn has modified.
+func (s *server) update(fn func(v *view) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := &view{enc: s.view.enc, lazy: s.view.lazy, docs: maps.Clone(s.view.docs)}
+	err := fn(v)
+	if err != nil {
+		return err
+	}
+	s.view = v
+	return nil
+}
+
+// Serve answers the requests of a client that writes to r and reads from w until the client exits.
+// Requests are handled one at a time, in order.
+func Serve(r io.Reader, w io.Writer) error {
+	s := newServer(newConn(r, w))
+
+	for {
+		m, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		var rerr *rpcError
+		if errors.As(err, &rerr) {
+			logging.WithError(err).Warn("skipping invalid message")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case m.Method == "exit":
+			return nil
+		case m.isRequest():
+			result, err := s.request(m)
+			err = s.conn.reply(m.ID, result, err)
+			if err != nil {
+				return err
+			}
+		case m.Method != "":
+			err := s.notification(m)
+			if err != nil {
+				logging.WithError(err).WithField("method", m.Method).Warn("handling notification")
+			}
+		}
+	}
+}
+
+func (s *server) request(m message) (any, error) {
+	if s.shutdown {
+		return nil, &rpcError{Code: codeInvalidRequest, Message: "the server is shut down"}
+	}
+
+	switch m.Method {
+	case "initialize":
+		p, err := decode[InitializeParams](m.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.initialize(p)
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/codeAction":
+		p, err := decode[CodeActionParams](m.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.currentView().codeActions(p)
+	case "codeAction/resolve":
+		a, err := decode[CodeAction](m.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.currentView().resolve(a)
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "unsupported method " + m.Method}
+}
+
+// notification handles the notifications that keep documents in sync. Others are ignored.
+func (s *server) notification(m message) error {
+	switch m.Method {
+	case "textDocument/didOpen":
+		p, err := decode[DidOpenTextDocumentParams](m.Params)
+		if err != nil {
+			return err
+		}
+		path, err := pathOf(p.TextDocument.URI)
+		if err != nil {
+			return err
+		}
+		return s.update(func(v *view) error {
+			v.docs[path] = document{version: p.TextDocument.Version, text: []byte(p.TextDocument.Text)}
+			return nil
+		})
+	case "textDocument/didChange":
+		p, err := decode[DidChangeTextDocumentParams](m.Params)
+		if err != nil {
+			return err
+		}
+		path, err := pathOf(p.TextDocument.URI)
+		if err != nil {
+			return err
+		}
+		return s.update(func(v *view) error {
+			doc, ok := v.docs[path]
+			if !ok {
+				return fmt.Errorf("%s is not open", p.TextDocument.URI)
+			}
+			for _, ch := range p.ContentChanges {
+				doc.text, err = v.applyChange(doc.text, ch)
+				if err != nil {
+					return err
+				}
+			}
+			if p.TextDocument.Version != nil {
+				doc.version = *p.TextDocument.Version
+			}
+			v.docs[path] = doc
+			return nil
+		})
+	case "textDocument/didClose":
+		p, err := decode[DidCloseTextDocumentParams](m.Params)
+		if err != nil {
+			return err
+		}
+		path, err := pathOf(p.TextDocument.URI)
+		if err != nil {
+			return err
+		}
+		return s.update(func(v *view) error {
+			delete(v.docs, path)
+			return nil
+		})
+	}
+	return nil
+}
+
+// applyChange returns text with ch applied. Changes without a range replace the whole text.
+func (v *view) applyChange(text []byte, ch TextDocumentContentChangeEvent) ([]byte, error) {
+	if ch.Range == nil {
+		return []byte(ch.Text), nil
+	}
+
+	c := file.Contents{Contents: text}
+	start, err := v.offsetOf(c, ch.Range.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := v.offsetOf(c, ch.Range.End)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, 0, len(text)-(end-start)+len(ch.Text))
+	res = append(res, text[:start]...)
+	res = append(res, ch.Text...)
+	return append(res, text[end:]...), nil
+}
+
+// initialize picks the first position encoding the client supports, or UTF-16, which every client
+// has to.
+func (s *server) initialize(p InitializeParams) (InitializeResult, error) {
+	enc := file.UTF16
+	for _, name := range p.Capabilities.General.PositionEncodings {
+		e, err := file.ParseColumnEncoding(name)
+		if err == nil {
+			enc = e
+			break
+		}
+	}
+	s.setEncoding(enc)
+	lazy := resolvesEdits(p.Capabilities)
+	s.setLazy(lazy)
+
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			PositionEncoding: enc.String(),
+			TextDocumentSync: TextDocumentSyncOptions{OpenClose: true, Change: syncFull},
+			CodeActionProvider: CodeActionOptions{
+				CodeActionKinds: []string{codeActionKind},
+				ResolveProvider: lazy,
+			},
+		},
+		ServerInfo: ServerInfo{Name: "go-tools"},
+	}, nil
+}
+
+func (s *server) setEncoding(enc file.ColumnEncoding) {
+	// Updates never fail without documents changing.
+	_ = s.update(func(v *view) error {
+		v.enc = enc
+		return nil
+	})
+}
+
+func (s *server) setLazy(lazy bool) {
+	_ = s.update(func(v *view) error {
+		v.lazy = lazy
+		return nil
+	})
+}
+
+// resolvesEdits reports whether a client with the capabilities c can fill in the edits of code
+// actions with codeAction/resolve.
+func resolvesEdits(c ClientCapabilities) bool {
+	return slices.Contains(c.TextDocument.CodeAction.ResolveSupport.Properties, "edit")
+}
+
+// codeActions returns an action for each suggestor that applies to the range. If the view is lazy,
+// the actions are only the suggestors that may apply, without their edits.
+func (v *view) codeActions(p CodeActionParams) ([]CodeAction, error) {
+	if !wantsKind(p.Context.Only, codeActionKind) {
+		return nil, nil
+	}
+
+	t, err := v.target(p.TextDocument.URI, p.Range)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []CodeAction
+	if v.lazy {
+		for _, name := range internal.Applicable(t.contents, t.start, t.end, t.cfg, v.overlay()) {
+			data, err := json.Marshal(codeActionData{
+				Suggestor: name,
+				URI:       p.TextDocument.URI,
+				Range:     p.Range,
+			})
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, CodeAction{Title: title(name), Kind: codeActionKind, Data: data})
+		}
+		return actions, nil
+	}
+
+	sugs, err := internal.GenerateSuggestions(t.contents, t.start, t.end, t.cfg, v.overlay())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sug := range sugs {
+		edit, err := v.workspaceEdit(t.contents, sug.Replacements)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, CodeAction{Title: title(sug.Name), Kind: codeActionKind, Edit: edit})
+	}
+	return actions, nil
+}
+
+// resolve fills in the edit of an action that codeActions returned without one.
+func (v *view) resolve(a CodeAction) (CodeAction, error) {
+	data, ok := actionData(a)
+	if !ok {
+		return CodeAction{}, &rpcError{Code: codeInvalidParams, Message: "not a go-tools code action"}
+	}
+
+	t, err := v.target(data.URI, data.Range)
+	if err != nil {
+		return CodeAction{}, err
+	}
+
+	sugs, err := internal.GenerateSuggestions(t.contents, t.start, t.end, t.cfg, v.overlay(), data.Suggestor)
+	if err != nil {
+		return CodeAction{}, err
+	}
+	if len(sugs) == 0 {
+		return CodeAction{}, fmt.Errorf("%s doesn't apply here", data.Suggestor)
+	}
+
+	a.Edit, err = v.workspaceEdit(t.contents, sugs[0].Replacements)
+	if err != nil {
+		return CodeAction{}, err
+	}
+	return a, nil
+}
+
+// actionData returns the data of an action that codeActions returned, or false if a isn't one of
+// ours.
+func actionData(a CodeAction) (codeActionData, bool) {
+	var data codeActionData
+	if json.Unmarshal(a.Data, &data) != nil || data.Suggestor == "" {
+		return codeActionData{}, false
+	}
+	return data, true
+}
+
+func title(suggestor string) string {
+	if t, ok := titles[suggestor]; ok {
+		return t
+	}
+	return suggestor
+}
+
+// target is what the suggestors run on for a code action.
+type target struct {
+	contents   file.Contents
+	start, end int
+	cfg        config.Config
+}
+
+// target returns what the suggestors run on for a code action on rng in the document uri.
+func (v *view) target(uri string, rng Range) (target, error) {
+	path, err := pathOf(uri)
+	if err != nil {
+		return target{}, err
+	}
+	contents, _, err := v.contents(path)
+	if err != nil {
+		return target{}, err
+	}
+
+	start, err := v.offsetOf(contents, rng.Start)
+	if err != nil {
+		return target{}, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	end, err := v.offsetOf(contents, rng.End)
+	if err != nil {
+		return target{}, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return target{}, fmt.Errorf("loading config: %w", err)
+	}
+	return target{contents: contents, start: start, end: end, cfg: cfg}, nil
+}
+
+// wantsKind reports whether kind is one of only, or a sub-kind of one, like refactor.rewrite is of
+// refactor.
+func wantsKind(only []string, kind string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, o := range only {
+		if kind == o || strings.HasPrefix(kind, o+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// contents returns the contents of the file at path: those of the open document, or else what's on
+// disk. exists reports whether there's a file at all; if not, the contents are empty.
+func (v *view) contents(path string) (c file.Contents, exists bool, err error) {
+	if doc, ok := v.docs[path]; ok {
+		return file.Contents{AbsPath: path, Contents: doc.text}, true, nil
+	}
+
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return file.Contents{AbsPath: path}, false, nil
+	}
+	if err != nil {
+		return file.Contents{}, false, err
+	}
+	return file.Contents{AbsPath: path, Contents: bs}, true, nil
+}
+
+func (v *view) overlay() map[string][]byte {
+	res := make(map[string][]byte, len(v.docs))
+	for path, doc := range v.docs {
+		res[path] = doc.text
+	}
+	return res
+}
+
+// workspaceEdit converts rs, which were generated for contents, to an edit.
+func (v *view) workspaceEdit(contents file.Contents, rs []file.Replacement) (*WorkspaceEdit, error) {
+	var paths []string
+	byPath := make(map[string][]file.Replacement)
+	for _, r := range rs {
+		path := r.AbsPath
+		if path == "" {
+			path = contents.AbsPath
+		}
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], r)
+	}
+
+	var docEdits []TextDocumentEdit
+	creates := make(map[string]bool)
+	for _, path := range paths {
+		c, exists, err := v.contents(path)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			creates[path] = true
+		}
+
+		var edits []TextEdit
+		for _, r := range byPath[path] {
+			rng, err := v.lspRange(c, r.Range)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, TextEdit{Range: rng, NewText: strings.Join(r.Lines, "\n")})
+		}
+
+		id := VersionedTextDocumentIdentifier{URI: uriOf(path)}
+		if doc, ok := v.docs[path]; ok {
+			id.Version = &doc.version
+		}
+		docEdits = append(docEdits, TextDocumentEdit{TextDocument: id, Edits: edits})
+	}
+
+	if len(creates) == 0 {
+		changes := make(map[string][]TextEdit, len(docEdits))
+		for _, e := range docEdits {
+			changes[e.TextDocument.URI] = e.Edits
+		}
+		return &WorkspaceEdit{Changes: changes}, nil
+	}
+
+	var changes []any
+	for i, path := range paths {
+		if creates[path] {
+			changes = append(changes, CreateFile{
+				Kind:    "create",
+				URI:     uriOf(path),
+				Options: CreateFileOptions{IgnoreIfExists: true},
+			})
+		}
+		changes = append(changes, docEdits[i])
+	}
+	return &WorkspaceEdit{DocumentChanges: changes}, nil
+}
+
+func (v *view) offsetOf(c file.Contents, pos Position) (int, error) {
+	return c.OffsetOf(file.Position{Line: pos.Line + 1, Col: pos.Character + 1}, v.enc)
+}
+
+func (v *view) lspRange(c file.Contents, r file.Range) (Range, error) {
+	start, err := c.ConvertPosition(r.Start, v.enc)
+	if err != nil {
+		return Range{}, err
+	}
+	stop, err := c.ConvertPosition(r.Stop, v.enc)
+	if err != nil {
+		return Range{}, err
+	}
+	return Range{
+		Start: Position{Line: start.Line - 1, Character: start.Col - 1},
+		End:   Position{Line: stop.Line - 1, Character: stop.Col - 1},
+	}, nil
+}
+
+func decode[T any](params json.RawMessage) (T, error) {
+	var p T
+	err := json.Unmarshal(params, &p)
+	if err != nil {
+		return p, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return p, nil
+}
+
+func pathOf(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI %s: only file URIs are", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+func uriOf(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,311 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+// client talks to a server started with Serve or Proxy.
+type client struct {
+	t    *testing.T
+	conn *conn
+	id   int
+	done chan error
+
+	// notifications are the methods of the notifications the server sent.
+	notifications []string
+}
+
+func startServer(t *testing.T) *client {
+	t.Helper()
+	return startWith(t, Serve)
+}
+
+func startWith(t *testing.T, serve func(r io.Reader, w io.Writer) error) *client {
+	t.Helper()
+	logging.InitLogger(io.Discard)
+
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+
+	c := &client{t: t, conn: newConn(toClient, fromClient), done: make(chan error, 1)}
+	go func() {
+		c.done <- serve(toServer, fromServer)
+		fromServer.Close()
+	}()
+	t.Cleanup(func() { fromClient.Close() })
+	return c
+}
+
+func (c *client) call(method string, params, result any) {
+	c.t.Helper()
+
+	c.id++
+	bs, err := json.Marshal(params)
+	must.NoError(c.t, err)
+	id := json.RawMessage(strconv.Itoa(c.id))
+	must.NoError(c.t, c.conn.write(message{ID: id, Method: method, Params: bs}))
+
+	m := c.next()
+	for m.ID == nil {
+		c.notifications = append(c.notifications, m.Method)
+		m = c.next()
+	}
+	must.Eq(c.t, "", m.Method)
+	must.Eq(c.t, string(id), string(m.ID))
+	must.Nil(c.t, m.Error)
+	if result != nil {
+		must.NoError(c.t, json.Unmarshal(m.Result, result))
+	}
+}
+
+// answer reads a request from the server and replies to it with result.
+func (c *client) answer(method string, result any) {
+	c.t.Helper()
+
+	m := c.next()
+	must.Eq(c.t, method, m.Method)
+	must.NotNil(c.t, m.ID)
+	must.NoError(c.t, c.conn.reply(m.ID, result, nil))
+}
+
+func (c *client) next() message {
+	c.t.Helper()
+
+	m, err := c.conn.read()
+	must.NoError(c.t, err)
+	return m
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+
+	bs, err := json.Marshal(params)
+	must.NoError(c.t, err)
+	must.NoError(c.t, c.conn.write(message{Method: method, Params: bs}))
+}
+
+// exit shuts the server down and waits for Serve to return.
+func (c *client) exit() {
+	c.t.Helper()
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	must.NoError(c.t, <-c.done)
+}
+
+// writeModule writes files to a fresh module and returns its directory.
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/test\n\ngo 1.21\n"
+	for name, contents := range files {
+		must.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644))
+	}
+	return dir
+}
+
+func actionTitled(t *testing.T, actions []CodeAction, title string) CodeAction {
+	t.Helper()
+
+	var titles []string
+	for _, a := range actions {
+		if a.Title == title {
+			return a
+		}
+		titles = append(titles, a.Title)
+	}
+	t.Fatalf("no action titled %q in %q", title, titles)
+	return CodeAction{}
+}
+
+// applyEdits applies edits to src, with positions counted in UTF-16.
+func applyEdits(t *testing.T, src string, edits []TextEdit) string {
+	t.Helper()
+
+	c := file.Contents{Contents: []byte(src)}
+	offset := func(p Position) int {
+		off, err := c.OffsetOf(file.Position{Line: p.Line + 1, Col: p.Character + 1}, file.UTF16)
+		must.NoError(t, err)
+		return off
+	}
+
+	// The edits don't overlap, so applying them from the last one keeps the others' offsets valid.
+	sort.Slice(edits, func(i, j int) bool {
+		return offset(edits[i].Range.Start) < offset(edits[j].Range.Start)
+	})
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		src = src[:offset(e.Range.Start)] + e.NewText + src[offset(e.Range.End):]
+	}
+	return src
+}
+
+func TestServe_CodeActions(t *testing.T) {
+	// The document is edited in the editor, so what's on disk is out of date.
+	dir := writeModule(t, map[string]string{"main.go": "package foo\n"})
+	uri := uriOf(filepath.Join(dir, "main.go"))
+
+	c := startServer(t)
+
+	var init InitializeResult
+	c.call("initialize", InitializeParams{}, &init)
+	test.Eq(t, "utf-16", init.Capabilities.PositionEncoding)
+	c.notify("initialized", struct{}{})
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI:        uri,
+		LanguageID: "go",
+		Version:    1,
+		Text:       "package foo\n",
+	}})
+
+	src := `package foo
+
+import "os"
+
+func foo() error {
+	f, err := os.Open("x")
+	_ = f
+	return nil
+}
+`
+	version := 2
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: &version},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: src}},
+	})
+
+	var actions []CodeAction
+	cursor := Position{Line: 5, Character: 5}
+	c.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+	}, &actions)
+
+	a := actionTitled(t, actions, "Add error check")
+	test.Eq(t, "refactor.rewrite", a.Kind)
+	must.MapLen(t, 1, a.Edit.Changes)
+	test.Eq(t, `package foo
+
+import "os"
+
+func foo() error {
+	f, err := os.Open("x")
+	if err != nil {
+		return err
+	}
+	_ = f
+	return nil
+}
+`, applyEdits(t, src, a.Edit.Changes[uri]))
+
+	// Asking for other kinds of actions gets none.
+	actions = nil
+	c.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+		Context:      CodeActionContext{Only: []string{"quickfix"}},
+	}, &actions)
+	test.SliceEmpty(t, actions)
+
+	c.exit()
+}
+
+func TestServe_Resolve(t *testing.T) {
+	src := `package foo
+
+import "os"
+
+func foo() error {
+	f, err := os.Open("x")
+	_ = f
+	return nil
+}
+`
+	dir := writeModule(t, map[string]string{"main.go": src})
+	uri := uriOf(filepath.Join(dir, "main.go"))
+
+	c := startServer(t)
+
+	var init InitializeResult
+	c.call("initialize", resolvingClient, &init)
+	test.True(t, init.Capabilities.CodeActionProvider.ResolveProvider)
+
+	var actions []CodeAction
+	cursor := Position{Line: 5, Character: 5}
+	c.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+	}, &actions)
+
+	// The edit is only generated once the action is resolved.
+	a := actionTitled(t, actions, "Add error check")
+	test.Nil(t, a.Edit)
+
+	var resolved CodeAction
+	c.call("codeAction/resolve", a, &resolved)
+	must.NotNil(t, resolved.Edit)
+	test.StrContains(t, applyEdits(t, src, resolved.Edit.Changes[uri]), "if err != nil {\n\t\treturn err\n")
+
+	c.exit()
+}
+
+// resolvingClient are the initialize params of a client that resolves the edits of code actions.
+var resolvingClient = map[string]any{
+	"capabilities": map[string]any{
+		"textDocument": map[string]any{
+			"codeAction": map[string]any{
+				"resolveSupport": map[string]any{"properties": []string{"edit"}},
+			},
+		},
+	},
+}
+
+func TestServe_CreatesFiles(t *testing.T) {
+	src := "package foo\n\nfunc Add(a, b int) int {\n\treturn a + b\n}\n"
+	dir := writeModule(t, map[string]string{"add.go": src})
+	uri := uriOf(filepath.Join(dir, "add.go"))
+
+	c := startServer(t)
+
+	var init InitializeResult
+	c.call("initialize", map[string]any{
+		"capabilities": map[string]any{
+			"general": map[string]any{"positionEncodings": []string{"utf-8", "utf-16"}},
+		},
+	}, &init)
+	test.Eq(t, "utf-8", init.Capabilities.PositionEncoding)
+
+	var actions []CodeAction
+	cursor := Position{Line: 2, Character: 6}
+	c.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: cursor, End: cursor},
+	}, &actions)
+
+	a := actionTitled(t, actions, "Generate table test")
+	must.Len(t, 2, a.Edit.DocumentChanges)
+
+	create, ok := a.Edit.DocumentChanges[0].(map[string]any)
+	must.True(t, ok)
+	test.Eq[any](t, "create", create["kind"])
+	test.Eq[any](t, uriOf(filepath.Join(dir, "add_test.go")), create["uri"])
+
+	edit, err := json.Marshal(a.Edit.DocumentChanges[1])
+	must.NoError(t, err)
+	test.StrContains(t, string(edit), "func TestAdd(")
+
+	c.exit()
+}
@@ -1,57 +1,298 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/cszczepaniak/go-tools/internal/config"
 	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/cszczepaniak/go-tools/internal/loader"
 	"github.com/cszczepaniak/go-tools/internal/logging"
 	"github.com/cszczepaniak/go-tools/internal/suggestions"
 	"github.com/cszczepaniak/go-tools/internal/suggestions/constructor"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/exhaustive"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/extract"
 	"github.com/cszczepaniak/go-tools/internal/suggestions/iferr"
 	"github.com/cszczepaniak/go-tools/internal/suggestions/selectorchain"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/stringer"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/structtags"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/tabletest"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/wrap"
+	"golang.org/x/tools/go/packages"
 )
 
+// GenerateReplacements runs the suggestors for the selection from start to end, and returns the
+// replacements of the first one that applies. If nothing is selected, start and end are both the
+// cursor offset.
 func GenerateReplacements(
 	contents file.Contents,
-	offset int,
-) (file.Replacement, error) {
-	needPkg := map[string]suggestions.PackageSuggestor{
-		"constructor": constructor.Generate,
-		"iferr":       iferr.Generate,
-	}
+	start, end int,
+	cfg config.Config,
+	only ...string,
+) ([]file.Replacement, error) {
+	return generate(contents, start, end, cfg, &Report{}, only)
+}
 
-	needFile := map[string]suggestions.FileSuggestor{
-		"selectorchain": selectorchain.Generate,
-	}
+// Profile is like GenerateReplacements, but also reports where the time went.
+func Profile(
+	contents file.Contents,
+	start, end int,
+	cfg config.Config,
+	only ...string,
+) (Report, error) {
+	rep := &Report{}
+	r, err := generate(contents, start, end, cfg, rep, only)
+	rep.Replacements = r
+	return *rep, err
+}
+
+// Suggestion is what one suggestor would do.
+type Suggestion struct {
+	// Name is the name of the suggestor, as passed to -only.
+	Name         string
+	Replacements []file.Replacement
+}
 
-	l := loader.New(contents, offset)
+// GenerateSuggestions is like GenerateReplacements, but returns the replacements of every
+// suggestor that applies, in the order GenerateReplacements tries them. That includes the ones
+// that otherwise only run when they're asked for by name. overlay has the contents of files that
+// differ from what's on disk, by absolute path. If only names suggestors, just those are run.
+//
+// A suggestor that fails is logged and left out, so that it doesn't hide the others.
+func GenerateSuggestions(
+	contents file.Contents,
+	start, end int,
+	cfg config.Config,
+	overlay map[string][]byte,
+	only ...string,
+) ([]Suggestion, error) {
+	rep := &Report{}
+	l := newLoader(contents, start, end, cfg, overlay)
 
-	for name, fn := range needFile {
-		t0 := time.Now()
-		r, err := fn(l, contents, offset)
-		logging.WithFields(map[string]any{"dur": time.Since(t0)}).Info(name + " finished")
+	var res []Suggestion
+	for _, s := range plan(cfg, only, start != end, true) {
+		r, err := rep.run(l, s.name, func() ([]file.Replacement, error) {
+			return formatted(contents, overlay)(s.fn(l, contents, start))
+		})
 		if err != nil {
-			return file.Replacement{}, err
+			logging.WithError(err).WithField("suggestor", s.name).Warn("suggestor failed")
+			continue
 		}
+		if len(r) != 0 {
+			res = append(res, Suggestion{Name: s.name, Replacements: r})
+		}
+	}
+	return res, nil
+}
+
+// errNeedsPackage is what syntaxOnly fails to load packages with.
+var errNeedsPackage = errors.New("the package is needed")
 
-		if len(r.Lines) != 0 {
-			return r, nil
+// syntaxOnly is a loader that can only parse the file.
+type syntaxOnly struct {
+	*loader.Loader
+}
+
+func (syntaxOnly) LoadPackage() (*packages.Package, error) {
+	return nil, errNeedsPackage
+}
+
+func (syntaxOnly) LoadScopedPackage() (*packages.Package, error) {
+	return nil, errNeedsPackage
+}
+
+// Applicable returns the names of the suggestors that may apply to the selection from start to
+// end, in the order GenerateSuggestions runs them. Only the file is parsed: a suggestor that gets
+// as far as loading the package is taken to apply. That way editors can list the suggestors on
+// every cursor move, and only generate the replacements of the one that's picked.
+func Applicable(
+	contents file.Contents,
+	start, end int,
+	cfg config.Config,
+	overlay map[string][]byte,
+) []string {
+	l := syntaxOnly{newLoader(contents, start, end, cfg, overlay)}
+
+	var res []string
+	for _, s := range plan(cfg, nil, start != end, true) {
+		r, err := s.fn(l, contents, start)
+		switch {
+		case errors.Is(err, errNeedsPackage), err == nil && len(r) != 0:
+			res = append(res, s.name)
+		case err != nil:
+			logging.WithError(err).WithField("suggestor", s.name).Warn("suggestor failed")
 		}
 	}
+	return res
+}
 
-	for name, fn := range needPkg {
-		t0 := time.Now()
-		r, err := fn(l, contents, offset)
-		logging.WithFields(map[string]any{"dur": time.Since(t0)}).Info(name + " finished")
-		if err != nil {
-			return file.Replacement{}, err
+func generate(
+	contents file.Contents,
+	offset, end int,
+	cfg config.Config,
+	rep *Report,
+	only []string,
+) ([]file.Replacement, error) {
+	l := newLoader(contents, offset, end, cfg, nil)
+	defer func() { rep.Loader = l.Stats() }()
+
+	for _, s := range plan(cfg, only, end != offset, false) {
+		r, err := rep.run(l, s.name, func() ([]file.Replacement, error) {
+			return formatted(contents, nil)(s.fn(l, contents, offset))
+		})
+		if err != nil || len(r) != 0 {
+			return r, err
+		}
+	}
+
+	return nil, nil
+}
+
+func newLoader(
+	contents file.Contents,
+	start, end int,
+	cfg config.Config,
+	overlay map[string][]byte,
+) *loader.Loader {
+	opts := loader.Options{Build: cfg.Build, Overlay: overlay}
+	if !cfg.Cache.Disable {
+		opts.CacheDir = cfg.Cache.Dir
+		opts.CacheMaxAge = time.Duration(cfg.Cache.MaxAgeDays) * 24 * time.Hour
+	}
+	return loader.NewSelection(contents, start, end, opts)
+}
+
+// plan returns the suggestors to try, in order: the ones named in only, or else the ones that run by
+// default for a cursor or a selection. With all set, the ones that otherwise have to be asked for
+// by name are included too.
+func plan(
+	cfg config.Config,
+	only []string,
+	selected, all bool,
+) []named[suggestions.PackageSuggestor] {
+	// These go first when there's a selection, since it says what to act on more precisely than the
+	// cursor does. Without one, they only run when they're asked for by name.
+	onSelection := []named[suggestions.PackageSuggestor]{
+		{"extractfunc", extract.Function},
+		{"extractvar", extract.Variable},
+	}
+
+	needPkg := []named[suggestions.PackageSuggestor]{
+		{"constructor", constructor.Generate},
+		{"exhaustive", exhaustive.Generate},
+		{"iferr", iferr.Generator(cfg.IfErr)},
+		{"stringer", stringer.Generator(cfg.Stringer)},
+		{"tabletest", tabletest.Generate},
+	}
+
+	// These are tried in order. selectorchain leaves argument lists alone, but it does claim chains
+	// nested inside of them, so it has to go before wrap.
+	needFile := []named[suggestions.FileSuggestor]{
+		{"selectorchain", selectorchain.Generator(cfg.SelectorChain, cfg.TabWidth)},
+		{"wrap", wrap.Generate},
+	}
+
+	// These act on the same nodes as the suggestors above (structtags and constructor both act on
+	// struct types), so they only run when they're asked for by name.
+	onRequest := []named[suggestions.FileSuggestor]{
+		{"structtags", structtags.Generator(cfg.StructTags)},
+	}
+
+	if len(only) > 0 || all {
+		needFile = append(needFile, onRequest...)
+	} else if !selected {
+		onSelection = nil
+	}
+
+	res := onSelection
+	for _, s := range needFile {
+		s := s
+		res = append(res, named[suggestions.PackageSuggestor]{s.name, func(
+			l suggestions.PackageLoader,
+			contents file.Contents,
+			offset int,
+		) ([]file.Replacement, error) {
+			return s.fn(l, contents, offset)
+		}})
+	}
+	res = append(res, needPkg...)
+
+	if len(only) > 0 {
+		res = onlyNamed(res, only)
+	}
+	return res
+}
+
+// formatted returns a function that runs gofmt on replacements in the context of the files they
+// apply to, so that generators don't have to get every space right and can't insert code that
+// doesn't parse. The result is then cut down to the lines that actually change, so generators can
+// re-emit whole nodes without disturbing the rest of them in the editor. It's shaped to wrap a
+// suggestor call. Other files come from overlay if they're in it, and from disk otherwise.
+func formatted(
+	contents file.Contents,
+	overlay map[string][]byte,
+) func([]file.Replacement, error) ([]file.Replacement, error) {
+	return func(rs []file.Replacement, err error) ([]file.Replacement, error) {
+		if err != nil || len(rs) == 0 {
+			return rs, err
+		}
+
+		var paths []string
+		byPath := make(map[string][]file.Replacement)
+		for _, r := range rs {
+			if _, ok := byPath[r.AbsPath]; !ok {
+				paths = append(paths, r.AbsPath)
+			}
+			byPath[r.AbsPath] = append(byPath[r.AbsPath], r)
 		}
 
-		if len(r.Lines) != 0 {
-			return r, nil
+		var res []file.Replacement
+		for _, p := range paths {
+			c := contents
+			if p != "" && p != contents.AbsPath {
+				bs, ok := overlay[p]
+				if !ok {
+					var err error
+					bs, err = os.ReadFile(p)
+					if err != nil && !errors.Is(err, fs.ErrNotExist) {
+						return nil, err
+					}
+				}
+				c = file.Contents{AbsPath: p, Contents: bs}
+			}
+
+			f, err := file.Format(c, byPath[p])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Base(c.AbsPath), err)
+			}
+
+			f, err = file.Minimize(c, f)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, f...)
 		}
+		return res, nil
 	}
+}
 
-	return file.Replacement{}, nil
+type named[T any] struct {
+	name string
+	fn   T
+}
+
+func onlyNamed[T any](suggestors []named[T], names []string) []named[T] {
+	var res []named[T]
+	for _, s := range suggestors {
+		for _, n := range names {
+			if s.name == n {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
 }
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/trace"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/loader"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+)
+
+// Report is a breakdown of where the time of one invocation went.
+type Report struct {
+	Replacements []file.Replacement
+	// Suggestors are the suggestors that ran, in order. Their durations don't include the time
+	// spent in the loader, which is accounted for in Loader.
+	Suggestors []Timing
+	Loader     loader.Stats
+}
+
+type Timing struct {
+	Name string
+	Dur  time.Duration
+}
+
+// run runs one suggestor and records how long it took. Replacements are sorted for applying.
+func (rep *Report) run(
+	l *loader.Loader,
+	name string,
+	fn func() ([]file.Replacement, error),
+) ([]file.Replacement, error) {
+	region := trace.StartRegion(context.Background(), name)
+	before := loaderTime(l.Stats())
+	t0 := time.Now()
+
+	r, err := fn()
+
+	dur := time.Since(t0)
+	region.End()
+	logging.WithFields(map[string]any{"dur": dur}).Info(name + " finished")
+
+	rep.Suggestors = append(rep.Suggestors, Timing{
+		Name: name,
+		Dur:  dur - (loaderTime(l.Stats()) - before),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	file.SortForApply(r)
+	return r, nil
+}
+
+func loaderTime(s loader.Stats) time.Duration {
+	return s.Parse + s.Load + s.TypeCheck
+}
+
+// Write prints the report as a table. encode is the time it took to encode the output, which
+// happens after the report is made.
+func (rep Report) Write(w io.Writer, encode time.Duration) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	total := loaderTime(rep.Loader) + encode
+	row := func(name string, d time.Duration) {
+		fmt.Fprintf(tw, "%s\t%s\t\n", name, d.Round(time.Microsecond))
+	}
+
+	fmt.Fprintf(tw, "phase\tduration\t\n")
+	row("parse", rep.Loader.Parse)
+	row("packages.Load", rep.Loader.Load)
+	row("typecheck", rep.Loader.TypeCheck)
+	for _, s := range rep.Suggestors {
+		row("suggestor "+s.Name, s.Dur)
+		total += s.Dur
+	}
+	row("encode", encode)
+	row("total", total)
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	st := rep.Loader
+	_, err = fmt.Fprintf(
+		w,
+		"\nfiles parsed: %d\nfunctions stripped: %d/%d\ndeclarations type checked: %d/%d\ncache hits/misses: %d/%d\nreplacements: %d\n",
+		st.FilesParsed,
+		st.FuncsStripped, st.FuncsSeen,
+		st.DeclsChecked, st.DeclsSeen,
+		st.CacheHits, st.CacheMisses,
+		len(rep.Replacements),
+	)
+	return err
+}
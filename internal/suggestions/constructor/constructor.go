@@ -2,11 +2,9 @@ package constructor
 
 import (
 	"errors"
+	"fmt"
 	"go/ast"
-	"go/format"
-	"go/token"
 	"go/types"
-	"strings"
 	"unicode"
 
 	"github.com/cszczepaniak/go-tools/internal/asthelper"
@@ -19,10 +17,10 @@ func Generate(
 	l suggestions.PackageLoader,
 	contents file.Contents,
 	offset int,
-) (file.Replacement, error) {
+) ([]file.Replacement, error) {
 	f, err := l.ParseFile()
 	if err != nil {
-		return file.Replacement{}, err
+		return nil, err
 	}
 
 	var typeDecl *ast.GenDecl
@@ -42,21 +40,21 @@ func Generate(
 	}
 
 	if typeDecl == nil || typeSpec == nil || typeSpec.Name == nil {
-		return file.Replacement{}, nil
+		return nil, nil
 	}
 
 	structType, ok := typeSpec.Type.(*ast.StructType)
 	if !ok {
-		return file.Replacement{}, nil
+		return nil, nil
 	}
 
-	lw := &linewriter.Writer{}
-
 	tokFile := f.Fset.File(typeDecl.Pos())
 	start := tokFile.Offset(typeDecl.Pos())
 	stop := tokFile.Offset(typeDecl.End())
 	bs := contents.BytesInRange(start, stop)
 
+	lw := linewriter.New(contents, start)
+
 	lw.Write(bs)
 	lw.Flush()
 
@@ -72,6 +70,9 @@ func Generate(
 		fnName = "New" + typeSpec.Name.Name
 	}
 
+	// The name is a placeholder, since it's only a guess.
+	namePos := lw.Next()
+	namePos.Col += len("func ")
 	lw.WriteLinef("func %s(", fnName)
 
 	type fieldInfo struct {
@@ -80,64 +81,58 @@ func Generate(
 		nameInFunc   string
 	}
 
-	idx := -1
-	maxStructMemberLen := 0
 	var fields []fieldInfo
-	for _, fld := range structType.Fields.List {
-		typStr, err := formatNodeToString(fld.Type)
-		if err != nil {
-			return file.Replacement{}, err
-		}
-
-		if len(fld.Names) == 0 {
-			idx++
-
+	for idx, sf := range asthelper.StructFields(structType) {
+		// The type is copied from the source rather than printed from the AST, which would drop the
+		// comments in it.
+		typ := sf.Decl.Type
+		typStr := string(contents.BytesInRange(tokFile.Offset(typ.Pos()), tokFile.Offset(typ.End())))
+
+		name := ""
+		if sf.Name != nil {
+			name = sf.Name.Name
+		} else {
+			// The name of an embedded field depends on what its type refers to.
 			t, err := loadStructType(l, typeSpec)
 			if err != nil {
-				return file.Replacement{}, err
-			}
-
-			v := t.Field(idx)
-
-			fields = append(fields, fieldInfo{
-				typeStr:      typStr,
-				nameInStruct: v.Name(),
-				nameInFunc:   lowerFirstRune(v.Name()),
-			})
-			maxStructMemberLen = max(maxStructMemberLen, len(v.Name()))
-		} else {
-			for _, n := range fld.Names {
-				idx++
-
-				fields = append(fields, fieldInfo{
-					typeStr:      typStr,
-					nameInStruct: n.Name,
-					nameInFunc:   lowerFirstRune(n.Name),
-				})
-				maxStructMemberLen = max(maxStructMemberLen, len(n.Name))
+				return nil, err
 			}
+			name = t.Field(idx).Name()
 		}
-	}
 
-	for _, f := range fields {
-		lw.WriteLinef("\t%s %s,", f.nameInFunc, f.typeStr)
+		fields = append(fields, fieldInfo{
+			typeStr:      typStr,
+			nameInStruct: name,
+			nameInFunc:   lowerFirstRune(name),
+		})
 	}
 
-	lw.WriteLinef(") %s {", typeSpec.Name.Name)
-	lw.WriteLinef("\treturn %s{", typeSpec.Name.Name)
-
+	lw.Indent()
 	for _, f := range fields {
-		padding := maxStructMemberLen - len(f.nameInStruct)
-		lw.WriteLinef("\t\t%s: %s%s,", f.nameInStruct, strings.Repeat(" ", padding), f.nameInFunc)
+		lw.WriteLinef("%s %s,", f.nameInFunc, f.typeStr)
 	}
+	lw.Dedent()
 
-	lw.WriteLinef("\t}")
-	lw.WriteLinef("}")
+	lw.Block(fmt.Sprintf(") %s {", typeSpec.Name.Name), func() {
+		lw.Block(fmt.Sprintf("return %s{", typeSpec.Name.Name), func() {
+			// The fields are aligned when the replacement is formatted.
+			for _, f := range fields {
+				lw.WriteLinef("%s: %s,", f.nameInStruct, f.nameInFunc)
+			}
+		})
+	})
 
-	return file.Replacement{
+	return []file.Replacement{{
 		Range: asthelper.RangeFromNode(f.Fset, typeDecl),
 		Lines: lw.TakeLines(),
-	}, err
+		Placeholders: []file.Placeholder{{
+			Index: 1,
+			Range: file.Range{
+				Start: namePos,
+				Stop:  file.Position{Line: namePos.Line, Col: namePos.Col + len(fnName)},
+			},
+		}},
+	}}, err
 }
 
 func lowerFirstRune(str string) string {
@@ -146,18 +141,8 @@ func lowerFirstRune(str string) string {
 	return string(rs)
 }
 
-func formatNodeToString(n ast.Node) (string, error) {
-	sb := &strings.Builder{}
-	err := format.Node(sb, token.NewFileSet(), n)
-	if err != nil {
-		return "", err
-	}
-
-	return sb.String(), nil
-}
-
 func loadStructType(l suggestions.PackageLoader, typeSpec *ast.TypeSpec) (*types.Struct, error) {
-	pkg, err := l.LoadPackage()
+	pkg, err := l.LoadScopedPackage()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,58 @@
+package constructor
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerate_KeepsComments(t *testing.T) {
+	src := `package foo
+
+// T is a thing.
+type <|>T struct {
+	// Name is the name.
+	Name string // not empty
+	Opts struct {
+		Verbose bool // chatty
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+// T is a thing.
+type T struct {
+	// Name is the name.
+	Name string // not empty
+	Opts struct {
+		Verbose bool // chatty
+	}
+}
+
+func NewT(
+	name string,
+	opts struct {
+		Verbose bool // chatty
+	},
+) T {
+	return T{
+		Name: name,
+		Opts: opts,
+	}
+}
+`, string(got))
+
+	// The name is offered as a placeholder.
+	must.Len(t, 1, rs[0].Placeholders)
+	ph := rs[0].Placeholders[0].Range
+	test.Eq(t, "NewT", rs[0].Lines[ph.Start.Line-1][ph.Start.Col-1:ph.Stop.Col-1])
+}
@@ -0,0 +1,396 @@
+package exhaustive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/linewriter"
+	"github.com/cszczepaniak/go-tools/internal/loader"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+	"golang.org/x/tools/go/packages"
+)
+
+// Generate fills in the missing cases of the switch statement under the cursor. Expression switches
+// on enum-like named types get a case for every constant of that type, and type switches on an
+// interface get a case for every type in the current package that implements it.
+func Generate(
+	l suggestions.PackageLoader,
+	contents file.Contents,
+	offset int,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "exhaustive"})
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	switchIdx, body := findSwitch(f)
+	if switchIdx == -1 {
+		e.Debug("cursor is not on a switch statement")
+		return nil, nil
+	}
+
+	pkg, err := l.LoadPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	q := newQualifier(f.File, pkg.Types)
+
+	var cases []string
+	var defaultStmt string
+	switch sw := f.ASTPath[switchIdx].(type) {
+	case *ast.SwitchStmt:
+		cases, defaultStmt, err = missingConstCases(pkg, q, contents, f, sw)
+	case *ast.TypeSwitchStmt:
+		cases, defaultStmt, err = missingTypeCases(pkg, q, sw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hasDefault := false
+	for _, stmt := range body.List {
+		if cc, ok := stmt.(*ast.CaseClause); ok && cc.List == nil {
+			hasDefault = true
+		}
+	}
+
+	if len(cases) == 0 && (hasDefault || defaultStmt == "") {
+		e.Debug("switch is already exhaustive")
+		return nil, nil
+	}
+
+	var rs []file.Replacement
+	if r, ok := asthelper.ImportReplacement(f.Fset, f.File, q.missing...); ok {
+		// The constants can come from a package that's only imported indirectly.
+		rs = append(rs, r)
+	}
+
+	sw := f.ASTPath[switchIdx]
+	tokFile := f.Fset.File(sw.Pos())
+	start := tokFile.Offset(sw.Pos())
+	stop := tokFile.Offset(body.Rbrace)
+
+	w := linewriter.New(contents, start)
+
+	// Keep everything up to the closing brace as-is, dropping the indentation before the brace so
+	// the new clauses can be written in its place.
+	existing := bytes.TrimRight(contents.BytesInRange(start, stop), " \t")
+	w.Write(bytes.TrimSuffix(existing, []byte("\n")))
+	w.Flush()
+
+	for _, c := range cases {
+		w.WriteLinef("case %s:", c)
+	}
+	if !hasDefault && defaultStmt != "" {
+		w.WriteLinef("default:")
+		w.Indent().WriteLinef("%s", defaultStmt).Dedent()
+	}
+	w.WriteLinef("}")
+
+	return append(rs, file.Replacement{
+		Range: asthelper.RangeFromNode(f.Fset, sw),
+		Lines: w.TakeLines(),
+	}), nil
+}
+
+// findSwitch returns the index in the AST path of the switch statement whose header contains the
+// cursor along with the switch's body, or -1 if the cursor is not on a switch statement.
+func findSwitch(f loader.File) (int, *ast.BlockStmt) {
+	for i, n := range f.ASTPath {
+		var body *ast.BlockStmt
+		switch n := n.(type) {
+		case *ast.SwitchStmt:
+			body = n.Body
+		case *ast.TypeSwitchStmt:
+			body = n.Body
+		default:
+			continue
+		}
+
+		// Only trigger on the header of the switch; inside the body, the cursor is more likely to
+		// be meant for one of the other suggestors.
+		if body == nil || f.Pos > body.Lbrace {
+			return -1, nil
+		}
+		return i, body
+	}
+
+	return -1, nil
+}
+
+func missingConstCases(
+	pkg *packages.Package,
+	q *qualifier,
+	contents file.Contents,
+	f loader.File,
+	sw *ast.SwitchStmt,
+) ([]string, string, error) {
+	if sw.Tag == nil {
+		return nil, "", nil
+	}
+
+	named, ok := pkg.TypesInfo.TypeOf(sw.Tag).(*types.Named)
+	if !ok {
+		return nil, "", nil
+	}
+
+	if _, ok := named.Underlying().(*types.Basic); !ok {
+		return nil, "", nil
+	}
+
+	defPkg := named.Obj().Pkg()
+	if defPkg == nil {
+		return nil, "", nil
+	}
+
+	var consts []*types.Const
+	scope := defPkg.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), named) {
+			continue
+		}
+		if defPkg != pkg.Types && !c.Exported() {
+			continue
+		}
+		consts = append(consts, c)
+	}
+
+	if len(consts) == 0 {
+		return nil, "", nil
+	}
+
+	sort.SliceStable(consts, func(i, j int) bool {
+		return consts[i].Pos() < consts[j].Pos()
+	})
+
+	covered := make(map[string]bool)
+	for _, stmt := range sw.Body.List {
+		cc, ok := stmt.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		for _, expr := range cc.List {
+			tv, ok := pkg.TypesInfo.Types[expr]
+			if ok && tv.Value != nil {
+				covered[tv.Value.ExactString()] = true
+			}
+		}
+	}
+
+	var cases []string
+	for _, c := range consts {
+		val := c.Val().ExactString()
+		if covered[val] {
+			continue
+		}
+		covered[val] = true
+		cases = append(cases, q.qualify(defPkg)+c.Name())
+	}
+
+	tokFile := f.Fset.File(sw.Tag.Pos())
+	tag := string(contents.BytesInRange(tokFile.Offset(sw.Tag.Pos()), tokFile.Offset(sw.Tag.End())))
+	typeName := named.Obj().Name()
+	if name, _ := q.nameOf(defPkg); name != "" {
+		// The name is only for the message, so it doesn't need the package to be imported.
+		typeName = name + "." + typeName
+	}
+
+	var defaultStmt string
+	if fmtName, ok := q.importedAs("fmt"); ok {
+		defaultStmt = fmt.Sprintf(`panic(%s.Sprintf("unexpected %s: %%v", %s))`, fmtName, typeName, tag)
+	} else {
+		defaultStmt = fmt.Sprintf("panic(%s)", strconv.Quote("unexpected "+typeName))
+	}
+
+	return cases, defaultStmt, nil
+}
+
+func missingTypeCases(
+	pkg *packages.Package,
+	q *qualifier,
+	sw *ast.TypeSwitchStmt,
+) ([]string, string, error) {
+	var assert *ast.TypeAssertExpr
+	var bound string
+	switch s := sw.Assign.(type) {
+	case *ast.ExprStmt:
+		assert, _ = s.X.(*ast.TypeAssertExpr)
+	case *ast.AssignStmt:
+		if len(s.Rhs) == 1 {
+			assert, _ = s.Rhs[0].(*ast.TypeAssertExpr)
+		}
+		if len(s.Lhs) == 1 {
+			if id, ok := s.Lhs[0].(*ast.Ident); ok {
+				bound = id.Name
+			}
+		}
+	}
+	if assert == nil {
+		return nil, "", errors.New("malformed type switch")
+	}
+
+	// The type is missing when the expression couldn't be type checked.
+	t := pkg.TypesInfo.TypeOf(assert.X)
+	if t == nil {
+		return nil, "", nil
+	}
+	iface, ok := t.Underlying().(*types.Interface)
+	if !ok {
+		return nil, "", nil
+	}
+
+	var covered []types.Type
+	for _, stmt := range sw.Body.List {
+		cc, ok := stmt.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		for _, expr := range cc.List {
+			if t := pkg.TypesInfo.TypeOf(expr); t != nil {
+				covered = append(covered, t)
+			}
+		}
+	}
+
+	isCovered := func(t types.Type) bool {
+		for _, c := range covered {
+			if types.Identical(c, t) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var typeNames []*types.TypeName
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() {
+			continue
+		}
+		named, ok := tn.Type().(*types.Named)
+		if !ok || named.TypeParams().Len() > 0 {
+			continue
+		}
+		if types.IsInterface(named) {
+			continue
+		}
+		typeNames = append(typeNames, tn)
+	}
+
+	sort.SliceStable(typeNames, func(i, j int) bool {
+		return typeNames[i].Pos() < typeNames[j].Pos()
+	})
+
+	var cases []string
+	for _, tn := range typeNames {
+		var t types.Type = tn.Type()
+		if !types.Implements(t, iface) {
+			t = types.NewPointer(t)
+			if !types.Implements(t, iface) {
+				continue
+			}
+		}
+		if isCovered(t) {
+			continue
+		}
+		cases = append(cases, types.TypeString(t, q.typesQualifier))
+	}
+
+	defaultStmt := `panic("unexpected type")`
+	if fmtName, ok := q.importedAs("fmt"); ok && bound != "" {
+		defaultStmt = fmt.Sprintf(`panic(%s.Sprintf("unexpected type %%T", %s))`, fmtName, bound)
+	}
+
+	return cases, defaultStmt, nil
+}
+
+// qualifier produces package qualifiers the way the current file refers to them.
+type qualifier struct {
+	current *types.Package
+	imports map[string]string
+	// missing are the import paths of the packages that were qualified but that the current file
+	// doesn't import.
+	missing []string
+}
+
+func newQualifier(f *ast.File, current *types.Package) *qualifier {
+	q := &qualifier{
+		current: current,
+		imports: make(map[string]string),
+	}
+
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		if imp.Name != nil {
+			q.imports[path] = imp.Name.Name
+			continue
+		}
+
+		for _, p := range current.Imports() {
+			if p.Path() == path {
+				q.imports[path] = p.Name()
+			}
+		}
+	}
+
+	return q
+}
+
+// importedAs returns the name the current file uses for the package with the given import path.
+func (q *qualifier) importedAs(path string) (string, bool) {
+	name, ok := q.imports[path]
+	if !ok || name == "_" || name == "." {
+		return "", false
+	}
+	return name, true
+}
+
+// qualify returns the prefix (including the trailing dot) needed to refer to a member of pkg.
+func (q *qualifier) qualify(pkg *types.Package) string {
+	name := q.typesQualifier(pkg)
+	if name == "" {
+		return ""
+	}
+	return name + "."
+}
+
+func (q *qualifier) typesQualifier(pkg *types.Package) string {
+	name, imported := q.nameOf(pkg)
+	if !imported && !slices.Contains(q.missing, pkg.Path()) {
+		q.missing = append(q.missing, pkg.Path())
+	}
+	return name
+}
+
+// nameOf returns the qualifier for members of pkg, and reports whether the current file can use it
+// without importing pkg.
+func (q *qualifier) nameOf(pkg *types.Package) (string, bool) {
+	if pkg == nil || pkg == q.current {
+		return "", true
+	}
+	if name, ok := q.imports[pkg.Path()]; ok && name != "_" {
+		if name == "." {
+			return "", true
+		}
+		return name, true
+	}
+	return pkg.Name(), false
+}
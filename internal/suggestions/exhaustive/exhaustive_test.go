@@ -0,0 +1,305 @@
+package exhaustive
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/loader"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerate_ConstSwitch(t *testing.T) {
+	src := `package foo
+
+import "fmt"
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+func foo(c Color) {
+	<|>switch c {
+	case Green:
+		fmt.Println("green")
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import "fmt"
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+func foo(c Color) {
+	switch c {
+	case Green:
+		fmt.Println("green")
+	case Red:
+	case Blue:
+	default:
+		panic(fmt.Sprintf("unexpected Color: %v", c))
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_ConstSwitch_Empty_NoFmt(t *testing.T) {
+	src := `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func foo(c Color) {
+	switch <|>c {}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func foo(c Color) {
+	switch c {
+	case Red:
+	case Green:
+	default:
+		panic("unexpected Color")
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_ConstSwitch_IndirectImport(t *testing.T) {
+	src := `package foo
+
+import "example.com/test/b"
+
+func foo() {
+	<|>switch b.Get() {
+	}
+}
+`
+
+	// With the cache, dependencies are type checked from source, so a has all of its constants.
+	l, contents, offset := suggestiontest.LoadWithOptions(t, "main.go", src, map[string]string{
+		"a/a.go": `package a
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+`,
+		"b/b.go": `package b
+
+import "example.com/test/a"
+
+func Get() a.Color { return a.Red }
+`,
+	}, loader.Options{CacheDir: t.TempDir()})
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import (
+	"example.com/test/a"
+	"example.com/test/b"
+)
+
+func foo() {
+	switch b.Get() {
+	case a.Red:
+	case a.Green:
+	default:
+		panic("unexpected a.Color")
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_TypeSwitch(t *testing.T) {
+	src := `package foo
+
+import "fmt"
+
+type Shape interface{ Area() float64 }
+
+type Square struct{}
+
+func (Square) Area() float64 { return 0 }
+
+type Circle struct{}
+
+func (*Circle) Area() float64 { return 0 }
+
+type NotAShape struct{}
+
+func foo(s Shape) {
+	swi<|>tch s := s.(type) {
+	case Square:
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import "fmt"
+
+type Shape interface{ Area() float64 }
+
+type Square struct{}
+
+func (Square) Area() float64 { return 0 }
+
+type Circle struct{}
+
+func (*Circle) Area() float64 { return 0 }
+
+type NotAShape struct{}
+
+func foo(s Shape) {
+	switch s := s.(type) {
+	case Square:
+	case *Circle:
+	default:
+		panic(fmt.Sprintf("unexpected type %T", s))
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_AlreadyExhaustive(t *testing.T) {
+	src := `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func foo(c Color) {
+	<|>switch c {
+	case Red, Green:
+	default:
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, r)
+}
+
+func TestGenerate_CursorInBody(t *testing.T) {
+	src := `package foo
+
+type Color int
+
+const Red Color = 0
+
+func foo(c Color) {
+	switch c {
+	<|>}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, r)
+}
+
+func TestGenerate_InCaseClause(t *testing.T) {
+	src := `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func foo(ok bool, c Color) {
+	switch {
+	case ok:
+		<|>switch c {
+		case Green:
+		}
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func foo(ok bool, c Color) {
+	switch {
+	case ok:
+		switch c {
+		case Green:
+		case Red:
+		default:
+			panic("unexpected Color")
+		}
+	}
+}
+`, string(got))
+}
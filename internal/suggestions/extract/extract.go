@@ -0,0 +1,155 @@
+// Package extract contains the refactorings that move a selection into a new declaration.
+package extract
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+)
+
+// qualifier produces package qualifiers the way the current file refers to them.
+type qualifier struct {
+	current *types.Package
+	imports map[string]string
+}
+
+func newQualifier(f *ast.File, current *types.Package) *qualifier {
+	q := &qualifier{
+		current: current,
+		imports: make(map[string]string),
+	}
+
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || imp.Name == nil {
+			continue
+		}
+		q.imports[path] = imp.Name.Name
+	}
+
+	return q
+}
+
+func (q *qualifier) typesQualifier(pkg *types.Package) string {
+	if pkg == nil || pkg == q.current {
+		return ""
+	}
+	if name, ok := q.imports[pkg.Path()]; ok {
+		if name == "." {
+			return ""
+		}
+		return name
+	}
+	return pkg.Name()
+}
+
+func (q *qualifier) typeString(t types.Type) string {
+	return types.TypeString(t, q.typesQualifier)
+}
+
+// zeroValue returns an expression for the zero value of t.
+func (q *qualifier) zeroValue(t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		case u.Kind() == types.UnsafePointer:
+			return "nil"
+		}
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature:
+		return "nil"
+	case *types.Interface:
+		if _, ok := t.(*types.TypeParam); !ok {
+			return "nil"
+		}
+	}
+
+	if _, ok := t.(*types.TypeParam); ok {
+		return "*new(" + q.typeString(t) + ")"
+	}
+	return q.typeString(t) + "{}"
+}
+
+// freeName returns name, or name followed by the smallest number from 2 up that isn't declared in
+// scope, its parents or the package.
+func freeName(pkg *types.Package, scope *types.Scope, name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		_, obj := scope.LookupParent(candidate, token.NoPos)
+		if obj == nil && pkg.Scope().Lookup(candidate) == nil {
+			return candidate
+		}
+		candidate = name + strconv.Itoa(i)
+	}
+}
+
+// reindent splits src into lines and swaps the base indent at the start of each line after the
+// first for indent.
+func reindent(src []byte, base, indent string) []string {
+	lines := strings.Split(string(src), "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "" {
+			continue
+		}
+		lines[i] = indent + strings.TrimPrefix(lines[i], base)
+	}
+	return lines
+}
+
+// edit replaces the bytes from start to end (relative to some source) with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// applyEdits applies edits, which must not overlap and must be sorted by start, to src.
+func applyEdits(src []byte, edits []edit) []byte {
+	var sb strings.Builder
+	last := 0
+	for _, e := range edits {
+		sb.Write(src[last:e.start])
+		sb.WriteString(e.text)
+		last = e.end
+	}
+	sb.Write(src[last:])
+	return []byte(sb.String())
+}
+
+// indentLines prefixes every line after the first with indent. The first line replaces text that
+// is already indented.
+func indentLines(lines []string, indent string) []string {
+	for i := 1; i < len(lines); i++ {
+		lines[i] = indent + lines[i]
+	}
+	return lines
+}
+
+// insertAfter returns a replacement that inserts lines after pos, separated from what comes before
+// by a blank line.
+func insertAfter(pos file.Position, lines []string) file.Replacement {
+	return file.Replacement{
+		Range: file.Range{Start: pos, Stop: pos},
+		Lines: append([]string{"", ""}, lines...),
+	}
+}
+
+// namePlaceholder returns a placeholder for the new name, which starts at line and col of a
+// replacement. Every use of the name shares an index, so editors can rename them all at once.
+func namePlaceholder(line, col int, name string) file.Placeholder {
+	return file.Placeholder{
+		Index: 1,
+		Range: file.Range{
+			Start: file.Position{Line: line, Col: col},
+			Stop:  file.Position{Line: line, Col: col + len(name)},
+		},
+	}
+}
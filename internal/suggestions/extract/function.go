@@ -0,0 +1,725 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+	"strings"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/loader"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+)
+
+// Function moves the selected statements into a new function after the one they're in, and
+// replaces them with a call. Variables from the surrounding function that the statements use
+// become parameters, and variables they set that are used afterwards become results. If the
+// statements return, the surrounding function has to return an error: the new function returns it
+// too, and the call is followed by a check that returns it.
+func Function(
+	l suggestions.PackageLoader,
+	contents file.Contents,
+	offset int,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "extractfunc"})
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Selection == nil {
+		return nil, nil
+	}
+
+	stmts, parent := selectedStmts(f.Selection)
+	if len(stmts) == 0 {
+		e.Debug("selection is not a list of statements")
+		return nil, nil
+	}
+
+	fn, decl := enclosingFunc(f.Selection.Path)
+	if decl == nil {
+		e.Debug("selection is not in a function")
+		return nil, nil
+	}
+
+	if branchEscapes(stmts) {
+		e.Info("selection contains a branch statement that leaves it")
+		return nil, nil
+	}
+
+	// The whole package is needed to know which names are free.
+	pkg, err := l.LoadPackage()
+	if err != nil {
+		return nil, err
+	}
+	info := pkg.TypesInfo
+
+	declObj, ok := info.Defs[decl.Name].(*types.Func)
+	if !ok {
+		return nil, fmt.Errorf("type info not found for %s", decl.Name.Name)
+	}
+	declSig := declObj.Type().(*types.Signature)
+	if declSig.TypeParams().Len() > 0 || declSig.RecvTypeParams().Len() > 0 {
+		e.Info("extracting from generic functions is not supported")
+		return nil, nil
+	}
+
+	sig := declSig
+	fnType := decl.Type
+	if lit, ok := fn.(*ast.FuncLit); ok {
+		fnType = lit.Type
+		sig, ok = info.TypeOf(lit).(*types.Signature)
+		if !ok {
+			return nil, errors.New("type info not found for the surrounding function literal")
+		}
+	}
+
+	scope := info.Scopes[parent]
+	if scope == nil {
+		// The scope of a function body is recorded on the function's type.
+		scope = info.Scopes[fnType]
+	}
+	if scope == nil {
+		return nil, errors.New("scope not found for the selection")
+	}
+
+	start, end := stmts[0].Pos(), stmts[len(stmts)-1].End()
+	vars, ok := collectVars(info, pkg.Types, stmts, start, end)
+	if !ok {
+		e.Info("selection uses a type or constant declared in the function")
+		return nil, nil
+	}
+
+	q := newQualifier(f.File, pkg.Types)
+
+	usedAfter := usesAfter(info, decl, fn, f.Selection.Path, start, end)
+
+	// A selection that ends in a return always returns, so the call returns what the new function
+	// does and nothing after it can use the selection's variables.
+	_, endsInReturn := stmts[len(stmts)-1].(*ast.ReturnStmt)
+
+	// Results are the variables declared in the selection that are used after it, and the ones
+	// from before it that the selection changes.
+	var results []*types.Var
+	for _, v := range vars.defined {
+		if usedAfter[v] && !endsInReturn {
+			results = append(results, v)
+		}
+	}
+	for _, v := range vars.params {
+		if vars.modified[v] && usedAfter[v] && !endsInReturn {
+			results = append(results, v)
+		}
+	}
+	slices.SortFunc(results, func(a, b *types.Var) int { return int(a.Pos() - b.Pos()) })
+
+	for _, v := range append(slices.Clone(vars.params), results...) {
+		if declaredInFunc(pkg.Types, v.Type()) {
+			e.Info("selection uses a type declared in the function")
+			return nil, nil
+		}
+	}
+
+	returns := returnStmts(stmts)
+	errName := ""
+	var errVar *types.Var
+	if len(returns) > 0 {
+		if !returnsError(sig) {
+			e.Info("selection returns from a function that doesn't return an error")
+			return nil, nil
+		}
+		if !onlyReturnsErrors(info, q, sig, returns) {
+			e.Info("selection has returns that aren't an error with zero values")
+			return nil, nil
+		}
+
+		errName = "err"
+		for i, v := range results {
+			if v.Name() == errName && isError(v.Type()) {
+				// The error from the selection goes in the variable it would have been in anyway.
+				errVar = v
+				results = slices.Delete(results, i, i+1)
+				break
+			}
+		}
+		if errVar == nil && !endsInReturn && (slices.ContainsFunc(vars.params, named(errName)) ||
+			slices.ContainsFunc(results, named(errName))) {
+			e.Info("err is already used in the selection")
+			return nil, nil
+		}
+	}
+
+	name := freeName(pkg.Types, scope, "extracted")
+
+	tokFile := f.Fset.File(start)
+	startOff, endOff := tokFile.Offset(start), tokFile.Offset(end)
+	base := contents.IndentAt(startOff)
+
+	// The new function.
+	var paramList, resultList, resultNames, zeros []string
+	for _, v := range vars.params {
+		paramList = append(paramList, v.Name()+" "+q.typeString(v.Type()))
+	}
+	for _, v := range results {
+		resultList = append(resultList, q.typeString(v.Type()))
+		resultNames = append(resultNames, v.Name())
+		zeros = append(zeros, q.zeroValue(v.Type()))
+	}
+	if errName != "" {
+		resultList = append(resultList, "error")
+	}
+
+	var edits []edit
+	for _, ret := range returns {
+		errExpr := ret.Results[len(ret.Results)-1]
+		edits = append(edits, edit{
+			start: tokFile.Offset(ret.Pos()) - startOff,
+			end:   tokFile.Offset(ret.End()) - startOff,
+			text: "return " + strings.Join(append(slices.Clone(zeros), string(contents.BytesInRange(
+				tokFile.Offset(errExpr.Pos()),
+				tokFile.Offset(errExpr.End()),
+			))), ", "),
+		})
+	}
+
+	header := "func " + name + "(" + strings.Join(paramList, ", ") + ")"
+	switch {
+	case len(resultList) == 1:
+		header += " " + resultList[0]
+	case len(resultList) > 1:
+		header += " (" + strings.Join(resultList, ", ") + ")"
+	}
+
+	body := reindent(applyEdits(contents.BytesInRange(startOff, endOff), edits), base, "\t")
+	body[0] = "\t" + body[0]
+
+	lines := append([]string{header + " {"}, body...)
+
+	if !endsInReturn && len(resultList) > 0 {
+		final := slices.Clone(resultNames)
+		if errName != "" {
+			final = append(final, "nil")
+		}
+		lines = append(lines, "\treturn "+strings.Join(final, ", "))
+	}
+	lines = append(lines, "}")
+
+	newFunc := insertAfter(asthelper.PositionFor(f.Fset, decl.End()), lines)
+	// insertAfter adds two lines before the header.
+	newFunc.Placeholders = []file.Placeholder{namePlaceholder(3, len("func ")+1, name)}
+
+	var args []string
+	for _, v := range vars.params {
+		args = append(args, v.Name())
+	}
+	callExpr := name + "(" + strings.Join(args, ", ") + ")"
+
+	var outer []string
+	for i := 0; i < sig.Results().Len()-1; i++ {
+		outer = append(outer, q.zeroValue(sig.Results().At(i).Type()))
+	}
+
+	selection := file.Range{
+		Start: asthelper.PositionFor(f.Fset, start),
+		Stop:  asthelper.PositionFor(f.Fset, end),
+	}
+
+	if endsInReturn {
+		ret := "return " + strings.Join(append(outer, ""), ", ")
+		return []file.Replacement{{
+			Range:        selection,
+			Lines:        []string{ret + callExpr},
+			Placeholders: []file.Placeholder{namePlaceholder(1, len(ret)+1, name)},
+		}, newFunc}, nil
+	}
+
+	// The call that replaces the selection.
+	type assignee struct {
+		name      string
+		typ       types.Type
+		existing  bool
+		sameScope bool
+	}
+	var lhs []assignee
+	for _, v := range results {
+		existing := !vars.isDefined(v)
+		lhs = append(lhs, assignee{v.Name(), v.Type(), existing, existing && v.Parent() == scope})
+	}
+	if errName != "" {
+		switch {
+		case errVar != nil:
+			existing := !vars.isDefined(errVar)
+			lhs = append(lhs, assignee{errName, errVar.Type(), existing, existing && errVar.Parent() == scope})
+		case scope.Lookup(errName) != nil && scope.Lookup(errName).Pos() < start:
+			lhs = append(lhs, assignee{errName, nil, true, true})
+		default:
+			lhs = append(lhs, assignee{errName, types.Universe.Lookup("error").Type(), false, false})
+		}
+	}
+
+	anyNew, shadows := false, false
+	var lhsNames []string
+	for _, a := range lhs {
+		anyNew = anyNew || !a.existing
+		shadows = shadows || (a.existing && !a.sameScope)
+		lhsNames = append(lhsNames, a.name)
+	}
+
+	var call []string
+	tok := "="
+	if shadows {
+		// := would declare new variables in this scope instead of setting the outer ones.
+		for _, a := range lhs {
+			if !a.existing {
+				call = append(call, "var "+a.name+" "+q.typeString(a.typ))
+			}
+		}
+	} else if anyNew {
+		tok = ":="
+	}
+
+	callLine := ""
+	if len(lhs) > 0 {
+		callLine = strings.Join(lhsNames, ", ") + " " + tok + " "
+	}
+	callCol := len(callLine)
+	if len(call) > 0 {
+		// Every line but the first is indented by indentLines.
+		callCol += len(base)
+	}
+	callName := namePlaceholder(len(call)+1, callCol+1, name)
+	call = append(call, callLine+callExpr)
+
+	if errName != "" {
+		call = append(call,
+			"if "+errName+" != nil {",
+			"\treturn "+strings.Join(append(outer, errName), ", "),
+			"}",
+		)
+	}
+
+	return []file.Replacement{{
+		Range:        selection,
+		Lines:        indentLines(call, base),
+		Placeholders: []file.Placeholder{callName},
+	}, newFunc}, nil
+}
+
+// selectedStmts returns the selected statements and the node whose list they're in.
+func selectedStmts(sel *loader.Selection) ([]ast.Stmt, ast.Node) {
+	parent := sel.Path[0]
+	nodes := sel.Nodes
+	if len(nodes) == 1 && nodes[0] == sel.Path[0] {
+		// A single selected statement is the same range as the expression it might wrap, so the
+		// selected node might be the expression.
+		for i, n := range sel.Path {
+			if n.Pos() != nodes[0].Pos() || n.End() != nodes[0].End() || i+1 == len(sel.Path) {
+				break
+			}
+			if _, ok := n.(ast.Stmt); ok {
+				nodes = []ast.Node{n}
+			}
+			parent = sel.Path[i+1]
+		}
+	}
+
+	switch parent.(type) {
+	case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+	default:
+		return nil, nil
+	}
+
+	var stmts []ast.Stmt
+	for _, n := range nodes {
+		s, ok := n.(ast.Stmt)
+		if !ok {
+			return nil, nil
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts, parent
+}
+
+// enclosingFunc returns the innermost function literal or declaration in path, and the top level
+// function declaration.
+func enclosingFunc(path []ast.Node) (ast.Node, *ast.FuncDecl) {
+	var fn ast.Node
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if fn == nil {
+				fn = n
+			}
+		case *ast.FuncDecl:
+			if fn == nil {
+				fn = n
+			}
+			return fn, n
+		}
+	}
+	return nil, nil
+}
+
+// branchEscapes reports whether a break, continue, goto or fallthrough in stmts leaves them.
+func branchEscapes(stmts []ast.Stmt) bool {
+	for _, s := range stmts {
+		if branchEscapesFrom(s, false, false) {
+			return true
+		}
+	}
+	return false
+}
+
+func branchEscapesFrom(root ast.Node, inLoop, inBreakable bool) bool {
+	escapes := false
+	ast.Inspect(root, func(n ast.Node) bool {
+		if escapes {
+			return false
+		}
+
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ForStmt:
+			escapes = branchEscapesFrom(n.Body, true, true)
+			return false
+		case *ast.RangeStmt:
+			escapes = branchEscapesFrom(n.Body, true, true)
+			return false
+		case *ast.SwitchStmt:
+			escapes = branchEscapesFrom(n.Body, inLoop, true)
+			return false
+		case *ast.TypeSwitchStmt:
+			escapes = branchEscapesFrom(n.Body, inLoop, true)
+			return false
+		case *ast.SelectStmt:
+			escapes = branchEscapesFrom(n.Body, inLoop, true)
+			return false
+		case *ast.BranchStmt:
+			switch {
+			case n.Label != nil, n.Tok == token.GOTO:
+				// Labels could be checked, but they're rare enough to not bother.
+				escapes = true
+			case n.Tok == token.CONTINUE:
+				escapes = !inLoop
+			default:
+				escapes = !inBreakable
+			}
+		}
+		return true
+	})
+	return escapes
+}
+
+// selectionVars are the variables that matter for a selection.
+type selectionVars struct {
+	// params are the local variables declared before the selection that it uses, in order of first
+	// use.
+	params []*types.Var
+	// defined are the variables declared at the top level of the selection, in order.
+	defined []*types.Var
+	// modified are the params that the selection may change.
+	modified map[*types.Var]bool
+}
+
+func (vs selectionVars) isDefined(v *types.Var) bool {
+	return slices.Contains(vs.defined, v)
+}
+
+// collectVars finds the variables used and declared by stmts. It reports false if they use a local
+// type or constant, which a new function couldn't see.
+func collectVars(
+	info *types.Info,
+	pkg *types.Package,
+	stmts []ast.Stmt,
+	start, end token.Pos,
+) (selectionVars, bool) {
+	vs := selectionVars{modified: make(map[*types.Var]bool)}
+	inside := func(p token.Pos) bool { return start <= p && p < end }
+	ok := true
+
+	local := func(obj types.Object) bool {
+		return obj != nil &&
+			obj.Pkg() == pkg &&
+			obj.Parent() != nil &&
+			obj.Parent() != pkg.Scope() &&
+			!inside(obj.Pos())
+	}
+
+	markModified := func(e ast.Expr) {
+		for {
+			switch x := e.(type) {
+			case *ast.ParenExpr:
+				e = x.X
+			case *ast.SelectorExpr:
+				e = x.X
+			case *ast.IndexExpr:
+				e = x.X
+			case *ast.Ident:
+				if v, ok := info.Uses[x].(*types.Var); ok && local(v) {
+					vs.modified[v] = true
+				}
+				return
+			default:
+				return
+			}
+		}
+	}
+
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.Ident:
+				switch obj := info.Uses[n].(type) {
+				case *types.Var:
+					if !obj.IsField() && local(obj) && !slices.Contains(vs.params, obj) {
+						vs.params = append(vs.params, obj)
+					}
+				case *types.TypeName, *types.Const:
+					if local(obj) {
+						ok = false
+					}
+				}
+			case *ast.AssignStmt:
+				for _, e := range n.Lhs {
+					markModified(e)
+				}
+			case *ast.IncDecStmt:
+				markModified(n.X)
+			case *ast.RangeStmt:
+				if n.Key != nil {
+					markModified(n.Key)
+				}
+				if n.Value != nil {
+					markModified(n.Value)
+				}
+			case *ast.UnaryExpr:
+				if n.Op == token.AND {
+					markModified(n.X)
+				}
+			case *ast.SelectorExpr:
+				// Methods with pointer receivers take the address of the value they're called on.
+				if sel, ok := info.Selections[n]; ok && sel.Kind() == types.MethodVal && pointerRecv(sel) {
+					markModified(n.X)
+				}
+			}
+			return true
+		})
+	}
+
+	for _, s := range stmts {
+		var idents []*ast.Ident
+		switch s := s.(type) {
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				for _, e := range s.Lhs {
+					if id, ok := e.(*ast.Ident); ok {
+						idents = append(idents, id)
+					}
+				}
+			}
+		case *ast.DeclStmt:
+			if gd, ok := s.Decl.(*ast.GenDecl); ok && gd.Tok == token.VAR {
+				for _, spec := range gd.Specs {
+					idents = append(idents, spec.(*ast.ValueSpec).Names...)
+				}
+			}
+		}
+
+		for _, id := range idents {
+			if v, ok := info.Defs[id].(*types.Var); ok {
+				vs.defined = append(vs.defined, v)
+			}
+		}
+	}
+
+	return vs, ok
+}
+
+// pointerRecv reports whether sel is a method with a pointer receiver that's selected on a value
+// that isn't a pointer.
+func pointerRecv(sel *types.Selection) bool {
+	recv := sel.Obj().(*types.Func).Type().(*types.Signature).Recv()
+	if recv == nil {
+		return false
+	}
+	_, ptr := recv.Type().(*types.Pointer)
+	_, onPtr := sel.Recv().Underlying().(*types.Pointer)
+	return ptr && !onPtr
+}
+
+// usesAfter returns the variables that are used after the selection from start to end in decl,
+// where fn is the innermost function around the selection. If the selection is in a loop, the
+// whole loop counts as after it. Variables that function literals outside the selection capture
+// count too, since the literals may run at any time, and so do fn's named results if it returns
+// without naming them.
+func usesAfter(
+	info *types.Info,
+	decl *ast.FuncDecl,
+	fn ast.Node,
+	path []ast.Node,
+	start, end token.Pos,
+) map[*types.Var]bool {
+	loopStart, loopEnd := token.NoPos, token.NoPos
+	for _, n := range path {
+		if n == fn {
+			break
+		}
+		switch n.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			loopStart, loopEnd = n.Pos(), n.End()
+		}
+	}
+	after := func(p token.Pos) bool {
+		return p >= end || (loopStart <= p && p < loopEnd && !(start <= p && p < end))
+	}
+
+	used := make(map[*types.Var]bool)
+	ast.Inspect(decl, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if start <= n.Pos() && n.End() <= end {
+				// Literals in the selection move with it.
+				break
+			}
+			for v := range captured(info, n) {
+				used[v] = true
+			}
+		case *ast.Ident:
+			if v, ok := info.Uses[n].(*types.Var); ok && after(n.Pos()) {
+				used[v] = true
+			}
+		}
+		return true
+	})
+
+	fnType := decl.Type
+	if lit, ok := fn.(*ast.FuncLit); ok {
+		fnType = lit.Type
+	}
+	if fnType.Results == nil || len(fnType.Results.List[0].Names) == 0 {
+		return used
+	}
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return n == fn
+		case *ast.ReturnStmt:
+			if len(n.Results) > 0 || !after(n.Pos()) {
+				return true
+			}
+			for _, field := range fnType.Results.List {
+				for _, name := range field.Names {
+					if v, ok := info.Defs[name].(*types.Var); ok {
+						used[v] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+	return used
+}
+
+// captured returns the variables that lit uses but that are declared outside of it.
+func captured(info *types.Info, lit *ast.FuncLit) map[*types.Var]bool {
+	vars := make(map[*types.Var]bool)
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		v, ok := info.Uses[id].(*types.Var)
+		if ok && !v.IsField() && (v.Pos() < lit.Pos() || v.Pos() >= lit.End()) {
+			vars[v] = true
+		}
+		return true
+	})
+	return vars
+}
+
+// returnStmts returns the return statements in stmts that return from the surrounding function.
+func returnStmts(stmts []ast.Stmt) []*ast.ReturnStmt {
+	var res []*ast.ReturnStmt
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ReturnStmt:
+				res = append(res, n)
+			}
+			return true
+		})
+	}
+	return res
+}
+
+func returnsError(sig *types.Signature) bool {
+	res := sig.Results()
+	return res.Len() > 0 && isError(res.At(res.Len()-1).Type())
+}
+
+// onlyReturnsErrors reports whether every return in returns returns an error along with zero
+// values for the other results. Those are the only returns a call to the new function can
+// reproduce.
+func onlyReturnsErrors(
+	info *types.Info,
+	q *qualifier,
+	sig *types.Signature,
+	returns []*ast.ReturnStmt,
+) bool {
+	for _, ret := range returns {
+		if len(ret.Results) != sig.Results().Len() {
+			return false
+		}
+
+		last := len(ret.Results) - 1
+		if id, ok := ret.Results[last].(*ast.Ident); ok && info.Uses[id] == types.Universe.Lookup("nil") {
+			return false
+		}
+
+		for i, r := range ret.Results[:last] {
+			if types.ExprString(r) != q.zeroValue(sig.Results().At(i).Type()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// declaredInFunc reports whether t refers to a type declared inside of a function.
+func declaredInFunc(pkg *types.Package, t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		return obj.Pkg() == pkg && obj.Parent() != nil && obj.Parent() != pkg.Scope()
+	case *types.Pointer:
+		return declaredInFunc(pkg, t.Elem())
+	case *types.Slice:
+		return declaredInFunc(pkg, t.Elem())
+	case *types.Array:
+		return declaredInFunc(pkg, t.Elem())
+	case *types.Chan:
+		return declaredInFunc(pkg, t.Elem())
+	case *types.Map:
+		return declaredInFunc(pkg, t.Key()) || declaredInFunc(pkg, t.Elem())
+	default:
+		return false
+	}
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+func named(name string) func(*types.Var) bool {
+	return func(v *types.Var) bool { return v.Name() == name }
+}
@@ -0,0 +1,416 @@
+package extract
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestFunction(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{{
+		name: "params and results",
+		src: `package foo
+
+func foo(a int) int {
+	b := 2
+	<[c := a + b
+	d := c * 2]>
+	return d
+}
+`,
+		want: `package foo
+
+func foo(a int) int {
+	b := 2
+	d := extracted(a, b)
+	return d
+}
+
+func extracted(a int, b int) int {
+	c := a + b
+	d := c * 2
+	return d
+}
+`,
+	}, {
+		name: "no params or results",
+		src: `package foo
+
+import "fmt"
+
+func foo() {
+	if true {
+		<[fmt.Println("a")
+		fmt.Println("b")]>
+	}
+}
+`,
+		want: `package foo
+
+import "fmt"
+
+func foo() {
+	if true {
+		extracted()
+	}
+}
+
+func extracted() {
+	fmt.Println("a")
+	fmt.Println("b")
+}
+`,
+	}, {
+		name: "error propagation",
+		src: `package foo
+
+import "os"
+
+func foo() (*os.File, int, error) {
+	<[f, err := os.Open("x")
+	if err != nil {
+		return nil, 0, err
+	}]>
+	return f, 1, nil
+}
+`,
+		want: `package foo
+
+import "os"
+
+func foo() (*os.File, int, error) {
+	f, err := extracted()
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, 1, nil
+}
+
+func extracted() (*os.File, error) {
+	f, err := os.Open("x")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+`,
+	}, {
+		name: "error used afterwards",
+		src: `package foo
+
+import "os"
+
+func foo() error {
+	<[f, err := os.Open("x")
+	if err != nil {
+		return err
+	}]>
+	_, err = f.Stat()
+	return err
+}
+`,
+		want: `package foo
+
+import "os"
+
+func foo() error {
+	f, err := extracted()
+	if err != nil {
+		return err
+	}
+	_, err = f.Stat()
+	return err
+}
+
+func extracted() (*os.File, error) {
+	f, err := os.Open("x")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+`,
+	}, {
+		name: "ends in a return",
+		src: `package foo
+
+func do() error { return nil }
+
+func foo(n int) (int, error) {
+	println(n)
+	<[err := do()
+	return 0, err]>
+}
+`,
+		want: `package foo
+
+func do() error { return nil }
+
+func foo(n int) (int, error) {
+	println(n)
+	return 0, extracted()
+}
+
+func extracted() error {
+	err := do()
+	return err
+}
+`,
+	}, {
+		name: "modified outer variable",
+		src: `package foo
+
+func foo(xs []int) int {
+	sum := 0
+	for _, x := range xs {
+		<[sum += x]>
+	}
+	return sum
+}
+`,
+		want: `package foo
+
+func foo(xs []int) int {
+	sum := 0
+	for _, x := range xs {
+		sum = extracted(sum, x)
+	}
+	return sum
+}
+
+func extracted(sum int, x int) int {
+	sum += x
+	return sum
+}
+`,
+	}, {
+		name: "modified in a closure",
+		src: `package foo
+
+func do(f func()) { f() }
+
+func foo() int {
+	count := 0
+	do(func() {
+		<[count++]>
+	})
+	return count
+}
+`,
+		want: `package foo
+
+func do(f func()) { f() }
+
+func foo() int {
+	count := 0
+	do(func() {
+		count = extracted(count)
+	})
+	return count
+}
+
+func extracted(count int) int {
+	count++
+	return count
+}
+`,
+	}, {
+		name: "named result with a bare return",
+		src: `package foo
+
+func foo() (n int, err error) {
+	<[n = 5]>
+	return
+}
+`,
+		want: `package foo
+
+func foo() (n int, err error) {
+	n = extracted(n)
+	return
+}
+
+func extracted(n int) int {
+	n = 5
+	return n
+}
+`,
+	}, {
+		name: "pointer receiver method",
+		src: `package foo
+
+type S struct{ n int }
+
+func (s *S) inc() { s.n++ }
+
+func foo() int {
+	var s S
+	<[s.inc()]>
+	return s.n
+}
+`,
+		want: `package foo
+
+type S struct{ n int }
+
+func (s *S) inc() { s.n++ }
+
+func foo() int {
+	var s S
+	s = extracted(s)
+	return s.n
+}
+
+func extracted(s S) S {
+	s.inc()
+	return s
+}
+`,
+	}, {
+		name: "name is taken",
+		src: `package foo
+
+func extracted() {}
+
+func foo() {
+	<[println(1)]>
+}
+`,
+		want: `package foo
+
+func extracted() {}
+
+func foo() {
+	extracted2()
+}
+
+func extracted2() {
+	println(1)
+}
+`,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, contents, offset := suggestiontest.Load(t, tc.src, nil)
+			rs, err := Function(l, contents, offset)
+			must.NoError(t, err)
+			got, err := file.Apply(contents, rs)
+			must.NoError(t, err)
+			test.Eq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestFunction_NotApplicable(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{{
+		name: "no selection",
+		src: `package foo
+
+func foo() {
+	<|>println(1)
+}
+`,
+	}, {
+		name: "expression",
+		src: `package foo
+
+func foo() {
+	println(<[1 + 2]>)
+}
+`,
+	}, {
+		name: "break out of the selection",
+		src: `package foo
+
+func foo() {
+	for {
+		<[println(1)
+		break]>
+	}
+}
+`,
+	}, {
+		name: "return without an error",
+		src: `package foo
+
+func foo() int {
+	<[if true {
+		return 1
+	}]>
+	return 2
+}
+`,
+	}, {
+		name: "return with non-zero values",
+		src: `package foo
+
+import "errors"
+
+func foo() (int, error) {
+	<[if true {
+		return 1, errors.New("x")
+	}]>
+	return 2, nil
+}
+`,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, contents, offset := suggestiontest.Load(t, tc.src, nil)
+			rs, err := Function(l, contents, offset)
+			must.NoError(t, err)
+			must.SliceEmpty(t, rs)
+		})
+	}
+}
+
+func TestFunction_Placeholders(t *testing.T) {
+	src := `package foo
+
+func foo(a int) int {
+	<[b := a * 2]>
+	return b
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Function(l, contents, offset)
+	must.NoError(t, err)
+	must.Eq(t, []string{"extracted", "extracted"}, placeholderTexts(t, contents, rs))
+}
+
+// placeholderTexts formats rs like they are before they're handed to an editor, and returns the
+// text of their placeholders in the result.
+func placeholderTexts(t *testing.T, contents file.Contents, rs []file.Replacement) []string {
+	t.Helper()
+
+	rs, err := file.Format(contents, rs)
+	must.NoError(t, err)
+	bs, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	out := file.Contents{Contents: bs}
+
+	var texts []string
+	for _, r := range rs {
+		for _, ph := range r.Placeholders {
+			start, err := out.OffsetOf(ph.Range.Start, file.UTF8)
+			must.NoError(t, err)
+			stop, err := out.OffsetOf(ph.Range.Stop, file.UTF8)
+			must.NoError(t, err)
+			texts = append(texts, string(out.BytesInRange(start, stop)))
+		}
+	}
+	return texts
+}
@@ -0,0 +1,306 @@
+package extract
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+)
+
+// Variable declares a new variable for the selected expression (or the expression under the
+// cursor) before the statement it's in, and replaces the expression with the variable. The name
+// comes from the function the expression calls or from its type.
+func Variable(
+	l suggestions.PackageLoader,
+	contents file.Contents,
+	offset int,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "extractvar"})
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	path := f.ASTPath
+	if f.Selection != nil {
+		if len(f.Selection.Nodes) != 1 || f.Selection.Nodes[0] != f.Selection.Path[0] {
+			e.Debug("selection is not a single node")
+			return nil, nil
+		}
+		path = f.Selection.Path
+	}
+
+	expr, stmt := expressionAndStatement(path, f.Selection != nil)
+	if expr == nil || stmt == nil {
+		e.Debug("no expression in a statement found")
+		return nil, nil
+	}
+
+	pkg, err := l.LoadScopedPackage()
+	if err != nil {
+		return nil, err
+	}
+	info := pkg.TypesInfo
+
+	tv, ok := info.Types[expr]
+	if !ok || !tv.IsValue() || tv.IsNil() {
+		e.Debug("expression is not a value")
+		return nil, nil
+	}
+	if _, ok := tv.Type.(*types.Tuple); ok {
+		e.Debug("expression has more than one value")
+		return nil, nil
+	}
+
+	if sel, ok := parentOf(path, expr).(*ast.SelectorExpr); ok {
+		if s, ok := info.Selections[sel]; ok && s.Kind() == types.MethodVal && pointerRecv(s) {
+			e.Info("expression is the receiver of a method with a pointer receiver")
+			return nil, nil
+		}
+	}
+
+	if declaredBetween(info, expr, stmt.Pos(), expr.Pos()) {
+		e.Info("expression uses a variable declared in its statement")
+		return nil, nil
+	}
+
+	scope := pkg.Types.Scope().Innermost(expr.Pos())
+	if scope == nil {
+		e.Debug("scope not found for the expression")
+		return nil, nil
+	}
+	name := freeName(pkg.Types, scope, variableName(expr, types.Default(tv.Type)))
+
+	// Constants are declared as constants so that they stay untyped; 1 passed as a float64 has to
+	// stay a float64.
+	keyword, declare := "", name+" := "
+	if tv.Value != nil {
+		keyword, declare = "const ", "const "+name+" = "
+	}
+
+	tokFile := f.Fset.File(expr.Pos())
+	exprStart, exprEnd := tokFile.Offset(expr.Pos()), tokFile.Offset(expr.End())
+	indent := contents.IndentAt(tokFile.Offset(stmt.Pos()))
+
+	decl := reindent(contents.BytesInRange(exprStart, exprEnd), contents.IndentAt(exprStart), indent)
+	decl[0] = declare + decl[0]
+
+	stmtStart := asthelper.PositionFor(f.Fset, stmt.Pos())
+	declName := namePlaceholder(1, len(keyword)+1, name)
+	if expr.Pos() == stmt.Pos() {
+		// The declaration and the expression start at the same place, so they're one replacement.
+		return []file.Replacement{{
+			Range:        file.Range{Start: stmtStart, Stop: asthelper.PositionFor(f.Fset, expr.End())},
+			Lines:        append(decl, indent+name),
+			Placeholders: []file.Placeholder{declName, namePlaceholder(len(decl)+1, len(indent)+1, name)},
+		}}, nil
+	}
+	return []file.Replacement{{
+		Range:        file.Range{Start: stmtStart, Stop: stmtStart},
+		Lines:        append(decl, indent),
+		Placeholders: []file.Placeholder{declName},
+	}, {
+		Range:        asthelper.RangeFromNode(f.Fset, expr),
+		Lines:        []string{name},
+		Placeholders: []file.Placeholder{namePlaceholder(1, 1, name)},
+	}}, nil
+}
+
+// expressionAndStatement finds the expression to extract in path and the statement to declare it
+// before. If selected is set, path[0] is the selected node, and it has to be the expression.
+// Otherwise the expression is the innermost one worth naming.
+func expressionAndStatement(path []ast.Node, selected bool) (ast.Expr, ast.Stmt) {
+	var expr ast.Expr
+	for i, n := range path {
+		if expr == nil {
+			e, ok := n.(ast.Expr)
+			if !ok {
+				if selected {
+					return nil, nil
+				}
+				continue
+			}
+
+			var parent ast.Node
+			if i+1 < len(path) {
+				parent = path[i+1]
+			}
+			if !canExtract(e, parent) {
+				if selected {
+					return nil, nil
+				}
+				continue
+			}
+			expr = e
+			continue
+		}
+
+		switch n := n.(type) {
+		case *ast.FuncLit, *ast.FuncDecl:
+			return nil, nil
+		case *ast.CaseClause, *ast.CommClause:
+			// The expression is in a case, which can't have statements before it.
+			return nil, nil
+		case *ast.BinaryExpr:
+			if (n.Op == token.LAND || n.Op == token.LOR) && asthelper.NodeContains(n.Y, expr.Pos()) {
+				// This only runs depending on the left operand.
+				return nil, nil
+			}
+		case *ast.IfStmt:
+			if i+1 < len(path) {
+				if parent, ok := path[i+1].(*ast.IfStmt); ok && parent.Else == n {
+					// The statement before an else if is the first if, which would run it even
+					// when the first branch is taken.
+					return nil, nil
+				}
+			}
+		case *ast.ForStmt:
+			if (n.Cond != nil && asthelper.NodeContains(n.Cond, expr.Pos())) ||
+				(n.Post != nil && asthelper.NodeContains(n.Post, expr.Pos())) {
+				// These run on every iteration.
+				return nil, nil
+			}
+		}
+
+		if i+1 < len(path) {
+			switch path[i+1].(type) {
+			case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+				if s, ok := n.(ast.Stmt); ok {
+					return expr, s
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// parentOf returns the node that n is directly in, according to path.
+func parentOf(path []ast.Node, n ast.Node) ast.Node {
+	for i, p := range path {
+		if p == n && i+1 < len(path) {
+			return path[i+1]
+		}
+	}
+	return nil
+}
+
+// canExtract reports whether e can be replaced by a variable. Names are skipped, since naming them
+// again doesn't help, and so are places that need e itself rather than its value.
+func canExtract(e ast.Expr, parent ast.Node) bool {
+	switch e.(type) {
+	case *ast.Ident, *ast.KeyValueExpr, *ast.ParenExpr:
+		return false
+	}
+
+	switch p := parent.(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range p.Lhs {
+			if lhs == e {
+				return false
+			}
+		}
+	case *ast.IncDecStmt:
+		return false
+	case *ast.RangeStmt:
+		return p.X == e
+	case *ast.UnaryExpr:
+		return p.Op != token.AND
+	case *ast.CallExpr:
+		// The function of a call is better off extracted with its call.
+		return p.Fun != e
+	}
+	return true
+}
+
+// declaredBetween reports whether expr uses a variable declared from start to end.
+func declaredBetween(info *types.Info, expr ast.Expr, start, end token.Pos) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if obj := info.Uses[id]; obj != nil && start <= obj.Pos() && obj.Pos() < end {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// variableName derives a name for a variable holding expr, which has type t. Calls are named
+// after the function, without a New or Get prefix; other expressions after their type.
+func variableName(expr ast.Expr, t types.Type) string {
+	var name string
+	if call, ok := expr.(*ast.CallExpr); ok {
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			name = fun.Name
+		case *ast.SelectorExpr:
+			name = fun.Sel.Name
+		}
+		for _, prefix := range []string{"New", "Get", "new", "get"} {
+			if rest := strings.TrimPrefix(name, prefix); rest != name && rest != "" {
+				name = rest
+				break
+			}
+		}
+	}
+
+	if name == "" {
+		name = typeName(t)
+	}
+
+	name = lowerFirst(name)
+	if !token.IsIdentifier(name) || token.IsKeyword(name) {
+		return "v"
+	}
+	return name
+}
+
+func typeName(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Named:
+		return t.Obj().Name()
+	case *types.Pointer:
+		return typeName(t.Elem())
+	case *types.Slice:
+		if elem := typeName(t.Elem()); len(elem) > 1 {
+			return elem + "s"
+		}
+	case *types.Map:
+		return "m"
+	case *types.Basic:
+		return t.Name()[:1]
+	}
+	return "v"
+}
+
+func lowerFirst(s string) string {
+	rs := []rune(s)
+	if len(rs) == 0 {
+		return s
+	}
+
+	// Initialisms are lowered as a whole, so URL becomes url and HTTPClient becomes httpClient.
+	i := 0
+	for i < len(rs) && unicode.IsUpper(rs[i]) {
+		i++
+	}
+	switch {
+	case i > 1 && i < len(rs):
+		i--
+	case i == 0:
+		return s
+	}
+
+	for j := 0; j < i; j++ {
+		rs[j] = unicode.ToLower(rs[j])
+	}
+	return string(rs)
+}
@@ -0,0 +1,314 @@
+package extract
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestVariable(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{{
+		name: "call under the cursor",
+		src: `package foo
+
+import "strings"
+
+func foo(s string) {
+	if true {
+		println(strings.To<|>Upper(s))
+	}
+}
+`,
+		want: `package foo
+
+import "strings"
+
+func foo(s string) {
+	if true {
+		toUpper := strings.ToUpper(s)
+		println(toUpper)
+	}
+}
+`,
+	}, {
+		name: "constructor",
+		src: `package foo
+
+import "bytes"
+
+func foo() {
+	println(bytes.<|>NewBuffer(nil).Len())
+}
+`,
+		want: `package foo
+
+import "bytes"
+
+func foo() {
+	buffer := bytes.NewBuffer(nil)
+	println(buffer.Len())
+}
+`,
+	}, {
+		name: "selected expression named after its type",
+		src: `package foo
+
+type URL struct{ Host string }
+
+func foo(u URL) {
+	x := <[URL{Host: u.Host}]>
+	_ = x
+}
+`,
+		want: `package foo
+
+type URL struct{ Host string }
+
+func foo(u URL) {
+	url := URL{Host: u.Host}
+	x := url
+	_ = x
+}
+`,
+	}, {
+		name: "name is taken",
+		src: `package foo
+
+func foo(a, b int) int {
+	i := 0
+	return <[a + b]> + i
+}
+`,
+		want: `package foo
+
+func foo(a, b int) int {
+	i := 0
+	i2 := a + b
+	return i2 + i
+}
+`,
+	}, {
+		name: "constant",
+		src: `package foo
+
+func foo(f float64) float64 {
+	return f * <[1.5]>
+}
+`,
+		want: `package foo
+
+func foo(f float64) float64 {
+	const f2 = 1.5
+	return f * f2
+}
+`,
+	}, {
+		name: "in a case clause",
+		src: `package foo
+
+func foo(s []int) {
+	switch {
+	case true:
+		println(<[len(s) * 2]>)
+	}
+}
+`,
+		want: `package foo
+
+func foo(s []int) {
+	switch {
+	case true:
+		i := len(s) * 2
+		println(i)
+	}
+}
+`,
+	}, {
+		name: "starts the statement",
+		src: `package foo
+
+type T struct{}
+
+func (T) Run() {}
+
+func mk() T { return T{} }
+
+func foo() {
+	m<|>k().Run()
+}
+`,
+		want: `package foo
+
+type T struct{}
+
+func (T) Run() {}
+
+func mk() T { return T{} }
+
+func foo() {
+	mk2 := mk()
+	mk2.Run()
+}
+`,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, contents, offset := suggestiontest.Load(t, tc.src, nil)
+			rs, err := Variable(l, contents, offset)
+			must.NoError(t, err)
+			got, err := file.Apply(contents, rs)
+			must.NoError(t, err)
+			test.Eq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestVariable_NotApplicable(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{{
+		name: "outside of a function",
+		src: `package foo
+
+var x = <[1 + 2]>
+`,
+	}, {
+		name: "loop condition",
+		src: `package foo
+
+func foo(s []int) {
+	for i := 0; i < <[len(s)]>; i++ {
+	}
+}
+`,
+	}, {
+		name: "uses a variable from the statement",
+		src: `package foo
+
+func foo() {
+	if x := 1; <[x + 1]> > 0 {
+	}
+}
+`,
+	}, {
+		name: "assigned to",
+		src: `package foo
+
+func foo(s []int) {
+	<[s[0]]> = 1
+}
+`,
+	}, {
+		name: "multiple values",
+		src: `package foo
+
+import "os"
+
+func foo() {
+	f, err := <[os.Open("x")]>
+	_, _ = f, err
+}
+`,
+	}, {
+		name: "case expression",
+		src: `package foo
+
+func foo(n int) {
+	switch n {
+	case <[n * 2]>:
+	}
+}
+`,
+	}, {
+		name: "else if condition",
+		src: `package foo
+
+func foo(n int) {
+	if n > 0 {
+	} else if <[n * 2]> > 4 {
+	}
+}
+`,
+	}, {
+		name: "right operand of &&",
+		src: `package foo
+
+func foo(s []int) bool {
+	return len(s) > 0 && <[s[0]]> == 1
+}
+`,
+	}, {
+		name: "inside the right operand of ||",
+		src: `package foo
+
+func foo(s []int) bool {
+	return len(s) == 0 || <[s[0]]>+1 == 2
+}
+`,
+	}, {
+		name: "receiver of a pointer method",
+		src: `package foo
+
+type C struct{ n int }
+
+func (c *C) inc() { c.n++ }
+
+type S struct{ c C }
+
+func foo(s S) {
+	<[s.c]>.inc()
+}
+`,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, contents, offset := suggestiontest.Load(t, tc.src, nil)
+			rs, err := Variable(l, contents, offset)
+			must.NoError(t, err)
+			must.SliceEmpty(t, rs)
+		})
+	}
+}
+
+func TestVariable_Placeholders(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	println(<[1 + 2]>)
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Variable(l, contents, offset)
+	must.NoError(t, err)
+	must.Eq(t, []string{"i", "i"}, placeholderTexts(t, contents, rs))
+}
+
+func TestVariable_PlaceholdersStartingTheStatement(t *testing.T) {
+	src := `package foo
+
+type T struct{}
+
+func (T) Run() {}
+
+func foo() {
+	<[T{}]>.Run()
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Variable(l, contents, offset)
+	must.NoError(t, err)
+	must.Len(t, 1, rs)
+	must.Eq(t, []string{"t", "t"}, placeholderTexts(t, contents, rs))
+}
@@ -6,25 +6,40 @@ import (
 	"go/ast"
 	"go/types"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/config"
 	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/cszczepaniak/go-tools/internal/linewriter"
 	"github.com/cszczepaniak/go-tools/internal/logging"
 	"github.com/cszczepaniak/go-tools/internal/suggestions"
+	"golang.org/x/tools/go/packages"
 )
 
-func Generate(
+// Generator returns a suggestor that checks the error assigned under the cursor. The error is
+// returned as it is, or wrapped with fmt.Errorf if opts say so.
+func Generator(opts config.IfErr) suggestions.PackageSuggestor {
+	return func(
+		l suggestions.PackageLoader,
+		contents file.Contents,
+		offset int,
+	) ([]file.Replacement, error) {
+		return generate(l, contents, opts)
+	}
+}
+
+func generate(
 	l suggestions.PackageLoader,
 	contents file.Contents,
-	offset int,
-) (file.Replacement, error) {
+	opts config.IfErr,
+) ([]file.Replacement, error) {
 	e := logging.WithFields(map[string]any{"handler": "iferr"})
 
 	f, err := l.ParseFile()
 	if err != nil {
-		return file.Replacement{}, err
+		return nil, err
 	}
 
 	assnStmt, surrounding := findAssignmentAndSurroundingFunc(f.ASTPath)
@@ -33,15 +48,14 @@ func Generate(
 			"surroundingNil": surrounding == nil,
 			"assnNil":        assnStmt == nil,
 		}).Info("surrounding function or assignment statement not found")
-		return file.Replacement{}, nil
+		return nil, nil
 	}
 
 	replacementRange := asthelper.RangeFromNode(f.Fset, assnStmt)
-	finalIndent := f.IndentLevel()
 
-	pkg, err := l.LoadPackage()
+	pkg, err := l.LoadScopedPackage()
 	if err != nil {
-		return file.Replacement{}, err
+		return nil, err
 	}
 
 	var funcTyp types.Type
@@ -53,7 +67,7 @@ func Generate(
 	}
 
 	if funcTyp == nil {
-		return file.Replacement{}, errors.New("type info not found for surrounding function")
+		return nil, errors.New("type info not found for surrounding function")
 	}
 
 	errName := ""
@@ -68,26 +82,22 @@ func Generate(
 
 	if errName == "" {
 		e.Info("lhs did not have an error type")
-		return file.Replacement{}, nil
+		return nil, nil
 	}
 
 	sig, ok := funcTyp.(*types.Signature)
 	if !ok {
-		return file.Replacement{}, errors.New("not a signature")
+		return nil, errors.New("not a signature")
 	}
 
-	w := &linewriter.Writer{}
-
 	tokFile := f.Fset.File(assnStmt.Pos())
 	start := tokFile.Offset(assnStmt.Pos())
 	stop := tokFile.Offset(assnStmt.End())
-	bs := contents.BytesInRange(start, stop)
 
-	w.Write(bs)
+	w := linewriter.New(contents, start)
+	w.Write(contents.BytesInRange(start, stop))
 	w.Flush()
 
-	w.WriteLinef("%sif %s != nil {", strings.Repeat("\t", finalIndent), errName)
-
 	totalResults := sig.Results().Len()
 
 	errIdx := -1
@@ -99,37 +109,107 @@ func Generate(
 		}
 	}
 
-	fmt.Fprint(w, strings.Repeat("\t", finalIndent+1))
+	var rs []file.Replacement
+
+	// msgCol is where the message the error is wrapped with starts in ret, so that it can be
+	// offered as a placeholder. It stays -1 if the error isn't wrapped.
+	msgCol := -1
+	msg := wrapMessage(assnStmt)
+	ret := &strings.Builder{}
 	if totalResults == 0 || errIdx == -1 {
 		// If the function we're in does not return anything or doesn't return an error
 		// anywhere, just panic with the error.
-		fmt.Fprintf(w, "panic(%s)", errName)
+		fmt.Fprintf(ret, "panic(%s)", errName)
 	} else {
-		fmt.Fprint(w, "return ")
+		fmt.Fprint(ret, "return ")
+
+		errorf, needFmt := asthelper.Qualified(f.File, "fmt", "Errorf")
+		if opts.Wrap && needFmt {
+			r, _ := asthelper.ImportReplacement(f.Fset, f.File, "fmt")
+			rs = append(rs, r)
+		}
 
 		for i := 0; i < totalResults; i++ {
-			if i == errIdx {
-				fmt.Fprint(w, errName)
-			} else {
+			switch {
+			case i == errIdx && !opts.Wrap:
+				fmt.Fprint(ret, errName)
+			case i != errIdx:
 				r := sig.Results().At(i)
-				err := printZeroValue(w, pkg.PkgPath, r.Type())
+				err := printZeroValue(ret, pkg.PkgPath, r.Type())
 				if err != nil {
-					return file.Replacement{}, err
+					return nil, err
 				}
+			default:
+				fmt.Fprintf(ret, "%s(\"", errorf)
+				msgCol = ret.Len()
+				fmt.Fprintf(ret, "%s: %s\", %s)", msg, wrapVerb(pkg, f.File), errName)
 			}
 
 			if i < totalResults-1 {
-				fmt.Fprint(w, ", ")
+				fmt.Fprint(ret, ", ")
 			}
 		}
 	}
-	w.Flush()
-	w.WriteLinef("%s}", strings.Repeat("\t", finalIndent))
 
-	return file.Replacement{
+	var retPos file.Position
+	w.Block(fmt.Sprintf("if %s != nil {", errName), func() {
+		retPos = w.Next()
+		w.WriteLinef("%s", ret)
+	})
+
+	r := file.Replacement{
 		Range: replacementRange,
 		Lines: w.TakeLines(),
-	}, nil
+	}
+	if msgCol >= 0 {
+		start := file.Position{Line: retPos.Line, Col: retPos.Col + msgCol}
+		r.Placeholders = []file.Placeholder{{
+			Index: 1,
+			Range: file.Range{
+				Start: start,
+				Stop:  file.Position{Line: start.Line, Col: start.Col + len(msg)},
+			},
+		}}
+	}
+
+	return append(rs, r), nil
+}
+
+// wrapMessage returns the message to wrap the error assigned in assn with, which is what's called to
+// get the error if that's a plain function or method. It's escaped to go in a string literal.
+func wrapMessage(assn *ast.AssignStmt) string {
+	if len(assn.Rhs) != 1 {
+		return "failed"
+	}
+	call, ok := assn.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return "failed"
+	}
+
+	switch fn := call.Fun.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		// Printing the expression drops the line breaks and comments of split selector chains.
+		quoted := strconv.Quote(types.ExprString(fn))
+		return quoted[1 : len(quoted)-1]
+	}
+	return "failed"
+}
+
+// wrapVerb returns the verb to wrap an error with in the language version of the file: %w, unless
+// the version is older than Go 1.13.
+func wrapVerb(pkg *packages.Package, f *ast.File) string {
+	v := f.GoVersion
+	if v == "" && pkg.Module != nil {
+		v = pkg.Module.GoVersion
+	}
+
+	major, minor, _ := strings.Cut(strings.TrimPrefix(v, "go"), ".")
+	minor, _, _ = strings.Cut(minor, ".")
+	n, err := strconv.Atoi(minor)
+	if major == "1" && err == nil && n < 13 {
+		return "%v"
+	}
+	return "%w"
 }
 
 func findAssignmentAndSurroundingFunc(
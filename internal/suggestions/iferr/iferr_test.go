@@ -0,0 +1,266 @@
+package iferr
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+		// placeholder is the text of the placeholder after applying, if there is one.
+		placeholder string
+	}{{
+		name: "wraps the error",
+		src: `package foo
+
+import (
+	"fmt"
+	"os"
+)
+
+type T struct{ A int }
+
+func foo() (T, error) {
+	f, <|>err := os.Open("x")
+	_ = f
+	return T{}, nil
+}
+
+var _ = fmt.Sprint
+`,
+		want: `package foo
+
+import (
+	"fmt"
+	"os"
+)
+
+type T struct{ A int }
+
+func foo() (T, error) {
+	f, err := os.Open("x")
+	if err != nil {
+		return T{}, fmt.Errorf("os.Open: %w", err)
+	}
+	_ = f
+	return T{}, nil
+}
+
+var _ = fmt.Sprint
+`,
+		placeholder: "os.Open",
+	}, {
+		name: "imports fmt",
+		src: `package foo
+
+func do() error { return nil }
+
+func foo() (int, error) {
+	<|>err := do()
+	return 0, err
+}
+`,
+		want: `package foo
+
+import "fmt"
+
+func do() error { return nil }
+
+func foo() (int, error) {
+	err := do()
+	if err != nil {
+		return 0, fmt.Errorf("do: %w", err)
+	}
+	return 0, err
+}
+`,
+		placeholder: "do",
+	}, {
+		name: "uses the name fmt is imported as",
+		src: `package foo
+
+import f "fmt"
+
+func do() error { return f.Errorf("x") }
+
+func foo() error {
+	<|>err := do()
+	return err
+}
+`,
+		want: `package foo
+
+import f "fmt"
+
+func do() error { return f.Errorf("x") }
+
+func foo() error {
+	err := do()
+	if err != nil {
+		return f.Errorf("do: %w", err)
+	}
+	return err
+}
+`,
+		placeholder: "do",
+	}, {
+		name: "split selector chain",
+		src: `package foo
+
+type C struct{}
+
+func (C) Do() error { return nil }
+
+func foo(c C) error {
+	<|>err := c.
+		Do()
+	return err
+}
+`,
+		want: `package foo
+
+import "fmt"
+
+type C struct{}
+
+func (C) Do() error { return nil }
+
+func foo(c C) error {
+	err := c.
+		Do()
+	if err != nil {
+		return fmt.Errorf("c.Do: %w", err)
+	}
+	return err
+}
+`,
+		placeholder: "c.Do",
+	}, {
+		name: "no error to return",
+		src: `package foo
+
+func do() error { return nil }
+
+func foo() {
+	<|>err := do()
+	_ = err
+}
+`,
+		want: `package foo
+
+func do() error { return nil }
+
+func foo() {
+	err := do()
+	if err != nil {
+		panic(err)
+	}
+	_ = err
+}
+`,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, contents, offset := suggestiontest.Load(t, tc.src, nil)
+			rs, err := Generator(config.IfErr{Wrap: true})(l, contents, offset)
+			must.NoError(t, err)
+			got, err := file.Apply(contents, rs)
+			must.NoError(t, err)
+			test.Eq(t, tc.want, string(got))
+
+			var want []string
+			if tc.placeholder != "" {
+				want = []string{tc.placeholder}
+			}
+			test.Eq(t, want, placeholderTexts(t, contents, rs))
+		})
+	}
+}
+
+func TestGenerate_OldGoVersion(t *testing.T) {
+	src := `//go:build go1.12
+
+package foo
+
+import "fmt"
+
+func do() error { return fmt.Errorf("x") }
+
+func foo() error {
+	<|>err := do()
+	return err
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.IfErr{Wrap: true})(l, contents, offset)
+	must.NoError(t, err)
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.StrContains(t, string(got), `return fmt.Errorf("do: %v", err)`)
+}
+
+func TestGenerate_Unwrapped(t *testing.T) {
+	src := `package foo
+
+import "os"
+
+func foo() (int, error) {
+	f, <|>err := os.Open("x")
+	_ = f
+	return 0, nil
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.IfErr{})(l, contents, offset)
+	must.NoError(t, err)
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import "os"
+
+func foo() (int, error) {
+	f, err := os.Open("x")
+	if err != nil {
+		return 0, err
+	}
+	_ = f
+	return 0, nil
+}
+`, string(got))
+	test.SliceEmpty(t, placeholderTexts(t, contents, rs))
+}
+
+// placeholderTexts returns the text each placeholder of rs covers once they're applied.
+func placeholderTexts(t *testing.T, contents file.Contents, rs []file.Replacement) []string {
+	t.Helper()
+
+	rs, err := file.Format(contents, rs)
+	must.NoError(t, err)
+	bs, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	out := file.Contents{Contents: bs}
+
+	var texts []string
+	for _, r := range rs {
+		for _, ph := range r.Placeholders {
+			start, err := out.OffsetOf(ph.Range.Start, file.UTF8)
+			must.NoError(t, err)
+			stop, err := out.OffsetOf(ph.Range.Stop, file.UTF8)
+			must.NoError(t, err)
+			texts = append(texts, string(out.BytesInRange(start, stop)))
+		}
+	}
+	return texts
+}
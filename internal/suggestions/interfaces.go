@@ -13,7 +13,9 @@ type FileParser interface {
 type PackageLoader interface {
 	FileParser
 	LoadPackage() (*packages.Package, error)
+	// LoadScopedPackage only type checks the declaration under the cursor and what it depends on.
+	LoadScopedPackage() (*packages.Package, error)
 }
 
-type FileSuggestor func(FileParser, file.Contents, int) (file.Replacement, error)
-type PackageSuggestor func(PackageLoader, file.Contents, int) (file.Replacement, error)
+type FileSuggestor func(FileParser, file.Contents, int) ([]file.Replacement, error)
+type PackageSuggestor func(PackageLoader, file.Contents, int) ([]file.Replacement, error)
@@ -1,143 +1,421 @@
 package selectorchain
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
+	"go/scanner"
 	"go/token"
 	"strings"
 
 	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/config"
 	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/cszczepaniak/go-tools/internal/linewriter"
 	"github.com/cszczepaniak/go-tools/internal/logging"
 	"github.com/cszczepaniak/go-tools/internal/suggestions"
 )
 
-func Generate(
+// Generator returns a suggestor that splits the selector chain under the cursor across lines, or
+// joins it back onto one line if it's already split.
+func Generator(opts config.SelectorChain, tabWidth int) suggestions.FileSuggestor {
+	return func(
+		l suggestions.FileParser,
+		contents file.Contents,
+		offset int,
+	) ([]file.Replacement, error) {
+		return generate(l, contents, opts, tabWidth)
+	}
+}
+
+func generate(
 	l suggestions.FileParser,
 	contents file.Contents,
-	offset int,
-) (file.Replacement, error) {
+	opts config.SelectorChain,
+	tabWidth int,
+) ([]file.Replacement, error) {
 	f, err := l.ParseFile()
 	if err != nil {
-		return file.Replacement{}, err
+		return nil, err
 	}
 
 	start := findStartOfChain(f.ASTPath)
 	if start == nil {
 		logging.Debug("selectorchain found no chain")
-		return file.Replacement{}, nil
+		return nil, nil
 	}
 
-	w := &linewriter.Writer{}
-	err = formatChain(
-		w,
-		f.Fset.File(start.Pos()),
-		f.IndentLevel(),
-		contents,
-		start,
-	)
+	if inArguments(f.ASTPath, start, f.Pos) {
+		logging.Debug("selectorchain: cursor is in the arguments of the chain")
+		return nil, nil
+	}
+
+	tokFile := f.Fset.File(start.Pos())
+	chainStart := tokFile.Offset(start.Pos())
+	chainStop := tokFile.Offset(start.End())
+
+	// The action toggles: a chain that's already split across lines gets joined back together.
+	w := linewriter.New(contents, chainStart)
+	switch {
+	case isSplit(tokFile, start):
+		err = joinChain(w, tokFile, contents, start)
+	case opts.Mode == "all":
+		err = formatChain(w, tokFile, contents, start)
+	case opts.Mode == "calls":
+		if lineWidth(contents.Contents, chainStart, chainStop, tabWidth) <= opts.MaxLineLength {
+			logging.Debug("selectorchain: the chain fits on its line")
+			return nil, nil
+		}
+		err = formatCallChain(w, tokFile, contents, start)
+	default:
+		return nil, fmt.Errorf("unknown selector chain mode %q", opts.Mode)
+	}
+	if errors.Is(err, errCommentInTheWay) {
+		logging.Debug("selectorchain: " + err.Error())
+		return nil, nil
+	}
 	if err != nil {
-		return file.Replacement{}, err
+		return nil, err
+	}
+
+	lines := w.TakeLines()
+
+	if strings.Join(lines, "\n") == string(contents.BytesInRange(chainStart, chainStop)) {
+		// There's nothing to split (a plain function call, for example); let the other suggestors
+		// have a go.
+		logging.Debug("selectorchain would not change the chain")
+		return nil, nil
 	}
 
 	rng := asthelper.RangeFromNode(f.Fset, start)
 
-	return file.Replacement{
+	return []file.Replacement{{
 		Range: rng,
-		Lines: w.TakeLines(),
-	}, nil
+		Lines: lines,
+	}}, nil
+}
+
+// inArguments reports whether pos is between the parentheses of one of the calls inside the chain
+// ending at start. Those belong to the wrap suggestor. The arguments of the last call still count as
+// the chain, since that's where the cursor is left after typing it.
+func inArguments(path []ast.Node, start ast.Node, pos token.Pos) bool {
+	for _, n := range path {
+		if n == start {
+			break
+		}
+		if c, ok := n.(*ast.CallExpr); ok && c.Lparen < pos && pos <= c.Rparen {
+			return true
+		}
+	}
+	return false
 }
 
+// separator returns what to write in place of the dot before the given selector or type
+// assertion.
+type separator func(link ast.Expr) string
+
+// formatChain writes the chain with each selector on its own line.
 func formatChain(
 	w *linewriter.Writer,
 	f *token.File,
-	indent int,
+	contents file.Contents,
+	n ast.Node,
+) error {
+	brk := continuation(w)
+	return writeChain(w, f, func(ast.Expr) string { return brk }, contents, n)
+}
+
+// formatCallChain writes the chain with a line break before each method call. Package qualifiers,
+// field selections and the first call in the chain stay attached to what comes before them.
+func formatCallChain(
+	w *linewriter.Writer,
+	f *token.File,
+	contents file.Contents,
+	n ast.Node,
+) error {
+	called := make(map[ast.Expr]bool)
+	for x := n; ; {
+		if c, ok := x.(*ast.CallExpr); ok {
+			called[c.Fun] = true
+		}
+
+		next, ok := operand(x)
+		if !ok {
+			break
+		}
+		x = next
+	}
+
+	brk := continuation(w)
+	return writeChain(w, f, func(link ast.Expr) string {
+		s, ok := link.(*ast.SelectorExpr)
+		if ok && called[s] && containsCall(s.X) {
+			return brk
+		}
+		return "."
+	}, contents, n)
+}
+
+// continuation returns what to write in place of a dot to continue the chain on the next line. The
+// links that spill onto new lines are indented one level more than the line the chain starts on.
+func continuation(w *linewriter.Writer) string {
+	return ".\n" + w.Indent().Indentation()
+}
+
+// joinChain writes the chain on a single line. Arguments are kept as they are.
+func joinChain(
+	w *linewriter.Writer,
+	f *token.File,
+	contents file.Contents,
+	n ast.Node,
+) error {
+	return writeChain(w, f, func(ast.Expr) string { return "." }, contents, n)
+}
+
+// containsCall reports whether any link in the chain ending at n is a call.
+func containsCall(n ast.Node) bool {
+	for {
+		if _, ok := n.(*ast.CallExpr); ok {
+			return true
+		}
+
+		x, ok := operand(n)
+		if !ok {
+			return false
+		}
+		n = x
+	}
+}
+
+// lineWidth returns the width of the widest source line spanned by the range between start and
+// stop, counting tabs as tabWidth columns.
+func lineWidth(src []byte, start, stop, tabWidth int) int {
+	lineStart := bytes.LastIndexByte(src[:start], '\n') + 1
+	lineStop := len(src)
+	if idx := bytes.IndexByte(src[stop:], '\n'); idx != -1 {
+		lineStop = stop + idx
+	}
+
+	widest := 0
+	for _, ln := range strings.Split(string(src[lineStart:lineStop]), "\n") {
+		width := 0
+		for _, r := range ln {
+			if r == '\t' {
+				width += tabWidth
+			} else {
+				width++
+			}
+		}
+		widest = max(widest, width)
+	}
+	return widest
+}
+
+// isSplit reports whether any selector in the chain starts on a different line than the link
+// before it.
+func isSplit(f *token.File, n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.SelectorExpr:
+		return f.Line(n.X.End()) != f.Line(n.Sel.Pos()) || isSplit(f, n.X)
+	case *ast.TypeAssertExpr:
+		return f.Line(n.X.End()) != f.Line(n.Lparen) || isSplit(f, n.X)
+	}
+
+	if x, ok := operand(n); ok {
+		return isSplit(f, x)
+	}
+	return false
+}
+
+// operand returns the expression that n applies to if n is a link in a postfix chain (a selector,
+// call, index, slice or type assertion).
+func operand(n ast.Node) (ast.Expr, bool) {
+	switch n := n.(type) {
+	case *ast.SelectorExpr:
+		return n.X, true
+	case *ast.CallExpr:
+		return n.Fun, true
+	case *ast.IndexExpr:
+		return n.X, true
+	case *ast.IndexListExpr:
+		return n.X, true
+	case *ast.SliceExpr:
+		return n.X, true
+	case *ast.TypeAssertExpr:
+		return n.X, true
+	default:
+		return nil, false
+	}
+}
+
+func writeChain(
+	w *linewriter.Writer,
+	f *token.File,
+	sep separator,
 	contents file.Contents,
 	n ast.Node,
 ) error {
 	switch n := n.(type) {
 	case *ast.CallExpr:
-		return formatCall(w, f, indent, contents, n)
+		return writeLink(w, f, sep, contents, n.Fun, n.Lparen, n.Rparen)
+	case *ast.IndexExpr:
+		return writeLink(w, f, sep, contents, n.X, n.Lbrack, n.Rbrack)
+	case *ast.IndexListExpr:
+		return writeLink(w, f, sep, contents, n.X, n.Lbrack, n.Rbrack)
+	case *ast.SliceExpr:
+		return writeLink(w, f, sep, contents, n.X, n.Lbrack, n.Rbrack)
+	case *ast.TypeAssertExpr:
+		err := writeChain(w, f, sep, contents, n.X)
+		if err != nil {
+			return err
+		}
+
+		err = writeSeparator(w, sep(n), commentsBetween(f, contents, n.X.End(), n.Lparen))
+		if err != nil {
+			return err
+		}
+
+		return writeVerbatim(w, f, contents, n.Lparen, n.Rparen+1)
 	case *ast.SelectorExpr:
-		return formatSel(w, f, indent, contents, n)
+		return writeSel(w, f, sep, contents, n)
 	case *ast.Ident:
 		_, err := w.Write([]byte(n.Name))
 		return err
-	default:
+	case nil:
 		return fmt.Errorf("unexpected node type in chain: %T", n)
+	default:
+		// Anything else is the operand the chain starts from, like a parenthesized expression or a
+		// composite literal, and is kept as it is.
+		return writeVerbatim(w, f, contents, n.Pos(), n.End())
 	}
 }
 
-func formatCall(
+// writeLink writes the chain up to x followed by the bracketed part of the link (arguments or
+// indices) as they appear in the source.
+func writeLink(
 	w *linewriter.Writer,
 	f *token.File,
-	indent int,
+	sep separator,
 	contents file.Contents,
-	c *ast.CallExpr,
+	x ast.Expr,
+	open, close token.Pos,
 ) error {
-	err := formatChain(w, f, indent, contents, c.Fun)
+	err := writeChain(w, f, sep, contents, x)
 	if err != nil {
 		return err
 	}
 
-	start := f.Offset(c.Lparen)
-	stop := f.Offset(c.Rparen) + 1
-	_, err = w.Write(contents.BytesInRange(start, stop))
+	return writeVerbatim(w, f, contents, open, close+1)
+}
+
+func writeVerbatim(
+	w *linewriter.Writer,
+	f *token.File,
+	contents file.Contents,
+	start, stop token.Pos,
+) error {
+	_, err := w.Write(contents.BytesInRange(f.Offset(start), f.Offset(stop)))
 	return err
 }
 
-func formatSel(
+func writeSel(
 	w *linewriter.Writer,
 	f *token.File,
-	indent int,
+	sep separator,
 	contents file.Contents,
 	s *ast.SelectorExpr,
 ) error {
-	err := formatChain(w, f, indent, contents, s.X)
+	err := writeChain(w, f, sep, contents, s.X)
 	if err != nil {
 		return err
 	}
 
-	// Add one more indent because we're going to indent the calls that spill onto new lines.
-	str := fmt.Sprintf(".\n%s", strings.Repeat("\t", indent+1))
-	_, err = w.Write([]byte(str))
+	err = writeSeparator(w, sep(s), commentsBetween(f, contents, s.X.End(), s.Sel.Pos()))
 	if err != nil {
 		return err
 	}
 
-	return formatChain(w, f, indent, contents, s.Sel)
+	return writeChain(w, f, sep, contents, s.Sel)
 }
 
-func findStartOfChain(path []ast.Node) ast.Node {
-	foundStart := false
-	for i := 0; i < len(path)-1; i++ {
-		curr := path[i]
-		next := path[i+1]
-
-		switch curr.(type) {
-		case *ast.SelectorExpr:
-			// If curr is a select, we keep going if next is a select or a call.
-			switch next.(type) {
-			case *ast.SelectorExpr, *ast.CallExpr:
-				continue
-			default:
-				return curr
+// errCommentInTheWay is returned when the chain can't be joined because one of its line comments
+// can't be turned into a block comment.
+var errCommentInTheWay = errors.New("line comment can't be put on one line")
+
+// writeSeparator writes sep along with the comments that were around the dot it replaces. If sep
+// breaks the line, the comments go at the end of the line, each after the first on a line of its
+// own. Otherwise they're written as block comments after the dot.
+func writeSeparator(w *linewriter.Writer, sep string, comments []string) error {
+	if len(comments) == 0 {
+		_, err := w.Write([]byte(sep))
+		return err
+	}
+
+	sb := &strings.Builder{}
+	dot, indent, broken := strings.Cut(sep, "\n")
+	sb.WriteString(dot)
+	if broken {
+		for i, c := range comments {
+			if i == 0 {
+				sb.WriteString(" ")
+			} else {
+				sb.WriteString("\n" + indent)
 			}
-		case *ast.CallExpr:
-			// If curr is a call, we keep going only if next is a select
-			switch next.(type) {
-			case *ast.SelectorExpr:
-				continue
-			default:
-				return curr
+			sb.WriteString(c)
+		}
+		sb.WriteString("\n" + indent)
+	} else {
+		for _, c := range comments {
+			bc, ok := asthelper.BlockComment(c)
+			if !ok {
+				return errCommentInTheWay
 			}
-		default:
-			if !foundStart {
-				continue
+			sb.WriteString(" " + bc)
+		}
+		sb.WriteString(" ")
+	}
+
+	_, err := w.Write([]byte(sb.String()))
+	return err
+}
+
+// commentsBetween returns the comments in the source from start to stop.
+func commentsBetween(f *token.File, contents file.Contents, start, stop token.Pos) []string {
+	src := contents.BytesInRange(f.Offset(start), f.Offset(stop))
+
+	var s scanner.Scanner
+	s.Init(token.NewFileSet().AddFile("", -1, len(src)), src, nil, scanner.ScanComments)
+
+	var comments []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return comments
+		}
+		if tok == token.COMMENT {
+			comments = append(comments, lit)
+		}
+	}
+}
+
+func findStartOfChain(path []ast.Node) ast.Node {
+	for i := 0; i < len(path); i++ {
+		curr := path[i]
+		if _, ok := operand(curr); !ok {
+			// Keep looking up the tree until we find the first link in the chain.
+			continue
+		}
+
+		// Keep going up for as long as the current node is the operand of the next link.
+		for ; i+1 < len(path); i++ {
+			x, ok := operand(path[i+1])
+			if !ok || x != path[i] {
+				break
 			}
-			return nil
 		}
+
+		return path[i]
 	}
 
 	return nil
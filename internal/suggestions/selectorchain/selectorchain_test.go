@@ -4,10 +4,13 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"strings"
 	"testing"
 
+	"github.com/cszczepaniak/go-tools/internal/config"
 	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/cszczepaniak/go-tools/internal/linewriter"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
 	"github.com/shoenig/test"
 	"github.com/shoenig/test/must"
 	"golang.org/x/tools/go/ast/astutil"
@@ -57,7 +60,6 @@ func TestFindStartOfChain_AllCalls(t *testing.T) {
 	err = formatChain(
 		w,
 		f,
-		0,
 		file.Contents{
 			Contents: []byte(src),
 		},
@@ -111,7 +113,6 @@ func TestFindStartOfChain_AllCalls_ButWithParams(t *testing.T) {
 	err = formatChain(
 		w,
 		f,
-		0,
 		file.Contents{
 			Contents: []byte(src),
 		},
@@ -168,7 +169,6 @@ func TestFindStartOfChain_AllCalls_ButWithParams_AndNewlines(t *testing.T) {
 	err = formatChain(
 		w,
 		f,
-		0,
 		file.Contents{
 			Contents: []byte(src),
 		},
@@ -231,7 +231,6 @@ func TestFindStartOfChain_AllNonCalls(t *testing.T) {
 	err = formatChain(
 		w,
 		f,
-		0,
 		file.Contents{
 			Contents: []byte(src),
 		},
@@ -302,3 +301,313 @@ func findIdent(t *testing.T, root *ast.File, name string) *ast.Ident {
 	must.NotNil(t, id)
 	return id
 }
+
+func TestJoinChain(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	A().
+		B(
+			x,
+			y,
+		).
+		C(y, z).
+		D()
+}`
+
+	fset := token.NewFileSet()
+	root, err := parser.ParseFile(fset, "", src, parser.AllErrors)
+	must.NoError(t, err)
+
+	b := findIdent(t, root, "B")
+	f := fset.File(b.Pos())
+
+	path, _ := astutil.PathEnclosingInterval(root, b.Pos(), b.Pos())
+	start := findStartOfChain(path)
+	must.NotNil(t, start)
+	test.True(t, isSplit(f, start))
+
+	w := &linewriter.Writer{}
+	err = joinChain(
+		w,
+		f,
+		file.Contents{
+			Contents: []byte(src),
+		},
+		start,
+	)
+	must.NoError(t, err)
+	test.Eq(
+		t,
+		[]string{
+			"A().B(",
+			"\t\t\tx,",
+			"\t\t\ty,",
+			"\t\t).C(y, z).D()",
+		},
+		w.TakeLines(),
+	)
+}
+
+func TestGenerate_Toggles(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	if true {
+		A().B<|>(x).C()
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	split := string(got)
+	test.Eq(t, `package foo
+
+func foo() {
+	if true {
+		A().
+			B(x).
+			C()
+	}
+}
+`, split)
+
+	l, contents, offset = suggestiontest.Load(t, strings.Replace(split, "B(x)", "B<|>(x)", 1), nil)
+	rs, err = Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+	got, err = file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, strings.Replace(src, "<|>", "", 1), string(got))
+}
+
+func TestFindStartOfChain_PostfixLinks(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	q.Where(x)[0].Get[T]().(*Foo).Do()
+}`
+
+	fset := token.NewFileSet()
+	root, err := parser.ParseFile(fset, "", src, parser.AllErrors)
+	must.NoError(t, err)
+
+	q := findIdent(t, root, "q")
+	f := fset.File(q.Pos())
+
+	path, _ := astutil.PathEnclosingInterval(root, q.Pos(), q.Pos())
+	start := findStartOfChain(path)
+
+	call, ok := start.(*ast.CallExpr)
+	must.True(t, ok)
+
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	must.True(t, ok)
+	test.Eq(t, "Do", sel.Sel.Name)
+
+	for _, name := range []string{"Where", "Get", "T", "Foo", "Do"} {
+		id := findIdent(t, root, name)
+		path, _ = astutil.PathEnclosingInterval(root, id.Pos(), id.Pos())
+		test.Eq(t, start, findStartOfChain(path))
+	}
+
+	contents := file.Contents{Contents: []byte(src)}
+
+	w := &linewriter.Writer{}
+	err = formatChain(w, f, contents, start)
+	must.NoError(t, err)
+
+	split := w.TakeLines()
+	test.Eq(
+		t,
+		[]string{
+			"q.",
+			"\tWhere(x)[0].",
+			"\tGet[T]().",
+			"\t(*Foo).",
+			"\tDo()",
+		},
+		split,
+	)
+
+	splitSrc := strings.Replace(src, "q.Where(x)[0].Get[T]().(*Foo).Do()", strings.Join(split, "\n"), 1)
+	root, err = parser.ParseFile(fset, "", splitSrc, parser.AllErrors)
+	must.NoError(t, err)
+
+	q = findIdent(t, root, "q")
+	f = fset.File(q.Pos())
+	path, _ = astutil.PathEnclosingInterval(root, q.Pos(), q.Pos())
+	start = findStartOfChain(path)
+	test.True(t, isSplit(f, start))
+
+	w = &linewriter.Writer{}
+	err = joinChain(w, f, file.Contents{Contents: []byte(splitSrc)}, start)
+	must.NoError(t, err)
+	test.Eq(t, []string{"q.Where(x)[0].Get[T]().(*Foo).Do()"}, w.TakeLines())
+}
+
+func TestFindStartOfChain_ParenRoot(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	(*p).A()[1:].B()
+}`
+
+	fset := token.NewFileSet()
+	root, err := parser.ParseFile(fset, "", src, parser.AllErrors)
+	must.NoError(t, err)
+
+	a := findIdent(t, root, "A")
+	f := fset.File(a.Pos())
+
+	path, _ := astutil.PathEnclosingInterval(root, a.Pos(), a.Pos())
+	start := findStartOfChain(path)
+
+	w := &linewriter.Writer{}
+	err = formatChain(w, f, file.Contents{Contents: []byte(src)}, start)
+	must.NoError(t, err)
+	test.Eq(t, []string{"(*p).", "\tA()[1:].", "\tB()"}, w.TakeLines())
+}
+
+func TestGenerate_PlainCall(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	bar(<|>x, y)
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+}
+
+func TestGenerate_CallsMode(t *testing.T) {
+	opts := config.SelectorChain{
+		Mode:          "calls",
+		MaxLineLength: 40,
+	}
+
+	src := `package foo
+
+func foo() {
+	s.db.Model(x).Where(y).Find(<|>z)
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(opts, 4)(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+
+	src = `package foo
+
+func foo() {
+	s.db.Model(x).Where(fmt.Sprint(y)).Find(<|>z)
+}
+`
+
+	l, contents, offset = suggestiontest.Load(t, src, nil)
+	rs, err = Generator(opts, 4)(l, contents, offset)
+	must.NoError(t, err)
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+func foo() {
+	s.db.Model(x).
+		Where(fmt.Sprint(y)).
+		Find(z)
+}
+`, string(got))
+
+	src = `package foo
+
+func foo() {
+	fmt.Println(<|>"a very long string that does not fit on the line")
+}
+`
+
+	l, contents, offset = suggestiontest.Load(t, src, nil)
+	rs, err = Generator(opts, 4)(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+}
+
+func TestGenerate_InArguments(t *testing.T) {
+	// The arguments of calls inside the chain are left to the wrap suggestor.
+	src := `package foo
+
+func foo() {
+	s.db.Model(x).Where(<|>y, z).Find(w)
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+}
+
+func TestGenerate_KeepsComments(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	A()./* a */B<|>(x).C()
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+func foo() {
+	A(). /* a */
+		B(x).
+		C()
+}
+`, string(got))
+
+	src = `package foo
+
+func foo() {
+	A(). // a
+		// b
+		B<|>(x).
+		C() // c
+}
+`
+
+	l, contents, offset = suggestiontest.Load(t, src, nil)
+	rs, err = Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+	got, err = file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+func foo() {
+	A(). /* a */ /* b */ B(x).C() // c
+}
+`, string(got))
+
+	src = `package foo
+
+func foo() {
+	A(). // a */
+		B<|>(x)
+}
+`
+
+	l, contents, offset = suggestiontest.Load(t, src, nil)
+	rs, err = Generator(config.Default().SelectorChain, 4)(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+}
@@ -0,0 +1,275 @@
+package stringer
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+	"strconv"
+	"unicode"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/linewriter"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+)
+
+// Generator returns a suggestor that generates a String method (and optionally text marshaling
+// methods and a parse function) for the enum declared by the const block under the cursor.
+func Generator(opts config.Stringer) suggestions.PackageSuggestor {
+	return func(
+		l suggestions.PackageLoader,
+		contents file.Contents,
+		offset int,
+	) ([]file.Replacement, error) {
+		return generate(l, contents, opts)
+	}
+}
+
+type enum struct {
+	named    *types.Named
+	unsigned bool
+	consts   []*types.Const
+}
+
+func generate(
+	l suggestions.PackageLoader,
+	contents file.Contents,
+	opts config.Stringer,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "stringer"})
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var constDecl *ast.GenDecl
+	for _, n := range f.ASTPath {
+		if gd, ok := n.(*ast.GenDecl); ok && gd.Tok == token.CONST && gd.Lparen.IsValid() {
+			constDecl = gd
+			break
+		}
+	}
+
+	if constDecl == nil || !usesIota(constDecl) {
+		e.Debug("cursor is not in a const block using iota")
+		return nil, nil
+	}
+
+	pkg, err := l.LoadPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	en, ok := findEnum(pkg.TypesInfo, constDecl)
+	if !ok {
+		e.Debug("const block does not declare constants of a named integer type")
+		return nil, nil
+	}
+
+	existing := make(map[string]bool)
+	recv := ""
+	for i := 0; i < en.named.NumMethods(); i++ {
+		m := en.named.Method(i)
+		existing[m.Name()] = true
+
+		r := m.Type().(*types.Signature).Recv()
+		if recv == "" && r != nil && r.Name() != "" && r.Name() != "_" {
+			recv = r.Name()
+		}
+	}
+	if recv == "" {
+		recv = string(unicode.ToLower([]rune(en.named.Obj().Name())[0]))
+	}
+
+	typeName := en.named.Obj().Name()
+	parseName := "parse" + upperFirstRune(typeName)
+	if en.named.Obj().Exported() {
+		parseName = "Parse" + typeName
+	}
+
+	wantString := !existing["String"]
+	wantMarshal := opts.TextMarshaling && !existing["MarshalText"] && !existing["UnmarshalText"]
+	wantParse := (opts.Parse || wantMarshal) && pkg.Types.Scope().Lookup(parseName) == nil
+
+	if !wantString && !wantMarshal && !wantParse {
+		e.Debug("all methods already exist")
+		return nil, nil
+	}
+
+	// The generated code refers to strconv and fmt the way the file does.
+	var imports []string
+	qualified := func(path, name string) string {
+		q, needImport := asthelper.Qualified(f.File, path, name)
+		if needImport && !slices.Contains(imports, path) {
+			imports = append(imports, path)
+		}
+		return q
+	}
+
+	w := &linewriter.Writer{}
+
+	tokFile := f.Fset.File(constDecl.Pos())
+	w.Write(contents.BytesInRange(tokFile.Offset(constDecl.Pos()), tokFile.Offset(constDecl.End())))
+	w.Flush()
+
+	if wantString {
+		format := "FormatInt"
+		if en.unsigned {
+			format = "FormatUint"
+		}
+		writeString(w, en, recv, qualified("strconv", format))
+	}
+	if wantMarshal {
+		writeMarshalText(w, en, recv, parseName)
+	}
+	if wantParse {
+		writeParse(w, en, parseName, qualified("fmt", "Errorf"))
+	}
+
+	rs := []file.Replacement{{
+		Range: asthelper.RangeFromNode(f.Fset, constDecl),
+		Lines: w.TakeLines(),
+	}}
+
+	if r, ok := asthelper.ImportReplacement(f.Fset, f.File, imports...); ok {
+		rs = append(rs, r)
+	}
+
+	return rs, nil
+}
+
+func usesIota(decl *ast.GenDecl) bool {
+	found := false
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "iota" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func findEnum(info *types.Info, decl *ast.GenDecl) (enum, bool) {
+	var en enum
+	seen := make(map[string]bool)
+
+	for _, spec := range decl.Specs {
+		vs, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+
+		for _, name := range vs.Names {
+			if name.Name == "_" {
+				continue
+			}
+
+			c, ok := info.Defs[name].(*types.Const)
+			if !ok {
+				continue
+			}
+
+			named, ok := c.Type().(*types.Named)
+			if !ok {
+				continue
+			}
+
+			basic, ok := named.Underlying().(*types.Basic)
+			if !ok || basic.Info()&types.IsInteger == 0 {
+				continue
+			}
+
+			if en.named == nil {
+				en.named = named
+				en.unsigned = basic.Info()&types.IsUnsigned != 0
+			}
+
+			if !types.Identical(named, en.named) {
+				continue
+			}
+
+			// Like stringer, only the first name for a given value is used.
+			val := c.Val().ExactString()
+			if seen[val] {
+				continue
+			}
+			seen[val] = true
+
+			en.consts = append(en.consts, c)
+		}
+	}
+
+	return en, en.named != nil
+}
+
+// writeString writes the String method. format is strconv.FormatInt, or strconv.FormatUint for
+// unsigned types.
+func writeString(w *linewriter.Writer, en enum, recv, format string) {
+	typeName := en.named.Obj().Name()
+
+	w.WriteLinef("")
+	w.WriteLinef("func (%s %s) String() string {", recv, typeName)
+	w.WriteLinef("\tswitch %s {", recv)
+	for _, c := range en.consts {
+		w.WriteLinef("\tcase %s:", c.Name())
+		w.WriteLinef("\t\treturn %s", strconv.Quote(c.Name()))
+	}
+	w.WriteLinef("\tdefault:")
+	conv := "int64"
+	if en.unsigned {
+		conv = "uint64"
+	}
+	w.WriteLinef(
+		"\t\treturn %s + %s(%s(%s), 10) + \")\"",
+		strconv.Quote(typeName+"("),
+		format,
+		conv,
+		recv,
+	)
+	w.WriteLinef("\t}")
+	w.WriteLinef("}")
+}
+
+func writeMarshalText(w *linewriter.Writer, en enum, recv, parseName string) {
+	typeName := en.named.Obj().Name()
+
+	w.WriteLinef("")
+	w.WriteLinef("func (%s %s) MarshalText() ([]byte, error) {", recv, typeName)
+	w.WriteLinef("\treturn []byte(%s.String()), nil", recv)
+	w.WriteLinef("}")
+	w.WriteLinef("")
+	w.WriteLinef("func (%s *%s) UnmarshalText(text []byte) error {", recv, typeName)
+	w.WriteLinef("\tv, err := %s(string(text))", parseName)
+	w.WriteLinef("\tif err != nil {")
+	w.WriteLinef("\t\treturn err")
+	w.WriteLinef("\t}")
+	w.WriteLinef("\t*%s = v", recv)
+	w.WriteLinef("\treturn nil")
+	w.WriteLinef("}")
+}
+
+func writeParse(w *linewriter.Writer, en enum, parseName, errorf string) {
+	typeName := en.named.Obj().Name()
+
+	w.WriteLinef("")
+	w.WriteLinef("func %s(s string) (%s, error) {", parseName, typeName)
+	w.WriteLinef("\tswitch s {")
+	for _, c := range en.consts {
+		w.WriteLinef("\tcase %s:", strconv.Quote(c.Name()))
+		w.WriteLinef("\t\treturn %s, nil", c.Name())
+	}
+	w.WriteLinef("\tdefault:")
+	w.WriteLinef("\t\treturn 0, %s(\"invalid %s: %%q\", s)", errorf, typeName)
+	w.WriteLinef("\t}")
+	w.WriteLinef("}")
+}
+
+func upperFirstRune(str string) string {
+	rs := []rune(str)
+	rs[0] = unicode.ToUpper(rs[0])
+	return string(rs)
+}
@@ -0,0 +1,196 @@
+package stringer
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerate_String(t *testing.T) {
+	src := `package foo
+
+import "os"
+
+var _ = os.Stdout
+
+type Color uint8
+
+const (
+	Red Color = iota
+	Gr<|>een
+	Blue
+	Blau = Blue
+)
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generator(config.Stringer{})(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import (
+	"os"
+	"strconv"
+)
+
+var _ = os.Stdout
+
+type Color uint8
+
+const (
+	Red Color = iota
+	Green
+	Blue
+	Blau = Blue
+)
+
+func (c Color) String() string {
+	switch c {
+	case Red:
+		return "Red"
+	case Green:
+		return "Green"
+	case Blue:
+		return "Blue"
+	default:
+		return "Color(" + strconv.FormatUint(uint64(c), 10) + ")"
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_AllOptions(t *testing.T) {
+	src := `package foo
+
+import (
+	"strconv"
+)
+
+var _ = strconv.Itoa
+
+type level int
+
+func (lvl level) isHigh() bool { return lvl > 1 }
+
+const (
+	low level = iota<|>
+	high
+)
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generator(config.Stringer{
+		TextMarshaling: true,
+		Parse:          true,
+	})(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import (
+	"strconv"
+	"fmt"
+)
+
+var _ = strconv.Itoa
+
+type level int
+
+func (lvl level) isHigh() bool { return lvl > 1 }
+
+const (
+	low level = iota
+	high
+)
+
+func (lvl level) String() string {
+	switch lvl {
+	case low:
+		return "low"
+	case high:
+		return "high"
+	default:
+		return "level(" + strconv.FormatInt(int64(lvl), 10) + ")"
+	}
+}
+
+func (lvl level) MarshalText() ([]byte, error) {
+	return []byte(lvl.String()), nil
+}
+
+func (lvl *level) UnmarshalText(text []byte) error {
+	v, err := parseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*lvl = v
+	return nil
+}
+
+func parseLevel(s string) (level, error) {
+	switch s {
+	case "low":
+		return low, nil
+	case "high":
+		return high, nil
+	default:
+		return 0, fmt.Errorf("invalid level: %q", s)
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_ImportAliases(t *testing.T) {
+	src := `package foo
+
+import (
+	_ "fmt"
+	f "fmt"
+	sc "strconv"
+)
+
+var _ = sc.Itoa
+var _ = f.Sprint
+
+type Color int
+
+const (
+	Red Color = iota<|>
+)
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generator(config.Stringer{Parse: true})(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, r)
+	must.NoError(t, err)
+	out := string(got)
+	test.StrContains(t, out, `return "Color(" + sc.FormatInt(int64(c), 10) + ")"`)
+	test.StrContains(t, out, `return 0, f.Errorf("invalid Color: %q", s)`)
+	test.StrContains(t, out, "import (\n\t_ \"fmt\"\n\tf \"fmt\"\n\tsc \"strconv\"\n)\n")
+}
+
+func TestGenerate_NotAnEnum(t *testing.T) {
+	src := `package foo
+
+const (
+	a = iota<|>
+	b
+)
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	r, err := Generator(config.Stringer{})(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, r)
+}
@@ -0,0 +1,66 @@
+package structtags
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// convertCase converts a Go identifier to the given naming convention.
+func convertCase(name, convention string) (string, error) {
+	words := splitWords(name)
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+	}
+
+	switch convention {
+	case "snake":
+		return strings.Join(words, "_"), nil
+	case "kebab":
+		return strings.Join(words, "-"), nil
+	case "camel":
+		for i := 1; i < len(words); i++ {
+			rs := []rune(words[i])
+			rs[0] = unicode.ToUpper(rs[0])
+			words[i] = string(rs)
+		}
+		return strings.Join(words, ""), nil
+	default:
+		return "", fmt.Errorf("unknown case convention %q", convention)
+	}
+}
+
+// splitWords splits a Go identifier into its words, keeping acronyms together: "HTTPServerID"
+// becomes "HTTP", "Server", "ID".
+func splitWords(name string) []string {
+	rs := []rune(name)
+
+	var words []string
+	start := 0
+	for i := 0; i < len(rs); i++ {
+		if rs[i] == '_' {
+			if i > start {
+				words = append(words, string(rs[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+
+		if i == start || !unicode.IsUpper(rs[i]) {
+			continue
+		}
+
+		prev := rs[i-1]
+		endsAcronym := unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1])
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) || endsAcronym {
+			words = append(words, string(rs[start:i]))
+			start = i
+		}
+	}
+
+	if start < len(rs) {
+		words = append(words, string(rs[start:]))
+	}
+
+	return words
+}
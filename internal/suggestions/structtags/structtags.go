@@ -0,0 +1,306 @@
+package structtags
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+)
+
+// Generator returns a suggestor that adds, removes or realigns the struct tags of the struct type
+// (or the single field) under the cursor.
+func Generator(opts config.StructTags) suggestions.FileSuggestor {
+	return func(
+		l suggestions.FileParser,
+		contents file.Contents,
+		offset int,
+	) ([]file.Replacement, error) {
+		return generate(l, contents, opts)
+	}
+}
+
+func generate(
+	l suggestions.FileParser,
+	contents file.Contents,
+	opts config.StructTags,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "structtags"})
+
+	switch opts.Mode {
+	case "add", "remove", "align":
+	default:
+		return nil, fmt.Errorf("unknown struct tag mode %q", opts.Mode)
+	}
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	st, target := findStruct(f.ASTPath)
+	if st == nil {
+		e.Debug("cursor is not on a struct type")
+		return nil, nil
+	}
+
+	tokFile := f.Fset.File(st.Pos())
+	structStart := tokFile.Offset(st.Pos())
+
+	// The tags are worked out for each field, and then put in place for each declaration, since the
+	// names declared together may need different tags.
+	var decls []taggedDecl
+	for _, sf := range asthelper.StructFields(st) {
+		// Embedded fields are left alone; tagging them would change how most encoders treat them.
+		if sf.Name == nil || (target != nil && sf.Decl != target) {
+			continue
+		}
+
+		tag, err := fieldTag(sf, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(decls) == 0 || decls[len(decls)-1].decl != sf.Decl {
+			decls = append(decls, taggedDecl{decl: sf.Decl})
+		}
+		decls[len(decls)-1].tags = append(decls[len(decls)-1].tags, tag)
+	}
+
+	edits := make([]edit, 0, len(decls))
+	for _, d := range decls {
+		edits = append(edits, editDecl(tokFile, contents, d))
+	}
+
+	src := applyEdits(
+		contents.BytesInRange(structStart, tokFile.Offset(st.End())),
+		structStart,
+		edits,
+	)
+
+	formatted, err := formatStruct(src)
+	if err != nil {
+		return nil, err
+	}
+
+	indent := contents.IndentAt(structStart)
+	lines := strings.Split(formatted, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+
+	if strings.Join(lines, "\n") == string(contents.BytesInRange(structStart, tokFile.Offset(st.End()))) {
+		e.Debug("struct tags are already up to date")
+		return nil, nil
+	}
+
+	return []file.Replacement{{
+		Range: asthelper.RangeFromNode(f.Fset, st),
+		Lines: lines,
+	}}, nil
+}
+
+// findStruct returns the innermost struct type containing the cursor, and the field under the
+// cursor if the cursor is on a single field.
+func findStruct(path []ast.Node) (*ast.StructType, *ast.Field) {
+	for i, n := range path {
+		st, ok := n.(*ast.StructType)
+		if !ok {
+			continue
+		}
+
+		if i >= 2 && path[i-1] == st.Fields {
+			if fld, ok := path[i-2].(*ast.Field); ok {
+				return st, fld
+			}
+		}
+		return st, nil
+	}
+
+	return nil, nil
+}
+
+type edit struct {
+	start, stop int
+	text        string
+}
+
+// taggedDecl is a field declaration with the tags of the names it declares, in order.
+type taggedDecl struct {
+	decl *ast.Field
+	tags []string
+}
+
+// fieldTag returns the tag that the field sf should have.
+func fieldTag(sf asthelper.StructField, opts config.StructTags) (string, error) {
+	var pairs []tagPair
+	if sf.Decl.Tag != nil {
+		tag, err := strconv.Unquote(sf.Decl.Tag.Value)
+		if err != nil {
+			return "", err
+		}
+
+		pairs, err = parseTag(tag)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if opts.Mode == "add" && !sf.Name.IsExported() {
+		// Unexported fields aren't encoded, but tags they already have can still be removed.
+		return formatTag(pairs), nil
+	}
+
+	pairs, err := updateTag(pairs, sf.Name.Name, opts)
+	if err != nil {
+		return "", err
+	}
+	return formatTag(pairs), nil
+}
+
+// editDecl returns the edit that puts the tags of d in place. A declaration of several names that
+// need different tags is split up.
+func editDecl(tokFile *token.File, contents file.Contents, d taggedDecl) edit {
+	fld, tags := d.decl, d.tags
+
+	typeEnd := tokFile.Offset(fld.Type.End())
+	stop := typeEnd
+	if fld.Tag != nil {
+		stop = tokFile.Offset(fld.Tag.End())
+	}
+
+	allSame := true
+	for _, t := range tags[1:] {
+		allSame = allSame && t == tags[0]
+	}
+
+	if allSame {
+		text := ""
+		if tags[0] != "" {
+			text = " " + tagLiteral(tags[0])
+		}
+		return edit{start: typeEnd, stop: stop, text: text}
+	}
+
+	// Each name needs its own tag, so the field has to be split up into one field per name.
+	typ := string(contents.BytesInRange(tokFile.Offset(fld.Type.Pos()), typeEnd))
+	lines := make([]string, 0, len(fld.Names))
+	for i, n := range fld.Names {
+		ln := n.Name + " " + typ
+		if tags[i] != "" {
+			ln += " " + tagLiteral(tags[i])
+		}
+		lines = append(lines, ln)
+	}
+
+	return edit{
+		start: tokFile.Offset(fld.Names[0].Pos()),
+		stop:  stop,
+		text:  strings.Join(lines, "\n"),
+	}
+}
+
+func updateTag(pairs []tagPair, fieldName string, opts config.StructTags) ([]tagPair, error) {
+	switch opts.Mode {
+	case "remove":
+		var kept []tagPair
+		for _, p := range pairs {
+			if !contains(opts.Keys, p.key) {
+				kept = append(kept, p)
+			}
+		}
+		return kept, nil
+	case "align":
+		return pairs, nil
+	}
+
+	pairs = append([]tagPair(nil), pairs...)
+	for _, key := range opts.Keys {
+		convention := opts.Case
+		if c, ok := opts.KeyCase[key]; ok {
+			convention = c
+		}
+
+		name, err := convertCase(fieldName, convention)
+		if err != nil {
+			return nil, err
+		}
+
+		idx := -1
+		for i, p := range pairs {
+			if p.key == key {
+				idx = i
+			}
+		}
+
+		if idx == -1 {
+			pairs = append(pairs, tagPair{key: key})
+			idx = len(pairs) - 1
+		}
+
+		parts := strings.Split(pairs[idx].value, ",")
+		if parts[0] == "-" {
+			// The field is explicitly skipped for this key.
+			continue
+		}
+
+		parts[0] = name
+		for _, o := range opts.Options[key] {
+			if !contains(parts[1:], o) {
+				parts = append(parts, o)
+			}
+		}
+		pairs[idx].value = strings.Join(parts, ",")
+	}
+
+	return pairs, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func applyEdits(src []byte, base int, edits []edit) []byte {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+
+	out := append([]byte(nil), src...)
+	for _, ed := range edits {
+		var buf bytes.Buffer
+		buf.Write(out[:ed.start-base])
+		buf.WriteString(ed.text)
+		buf.Write(out[ed.stop-base:])
+		out = buf.Bytes()
+	}
+	return out
+}
+
+// formatStruct runs gofmt over the given struct type so the tags are aligned the same way gofmt
+// would align them.
+func formatStruct(src []byte) (string, error) {
+	const prefix = "package p\n\ntype _ "
+
+	formatted, err := format.Source([]byte(prefix + string(src) + "\n"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(string(formatted), prefix), "\n"), nil
+}
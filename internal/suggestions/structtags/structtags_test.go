@@ -0,0 +1,155 @@
+package structtags
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestConvertCase(t *testing.T) {
+	tests := []struct {
+		name  string
+		snake string
+		camel string
+		kebab string
+	}{
+		{name: "ID", snake: "id", camel: "id", kebab: "id"},
+		{name: "UserID", snake: "user_id", camel: "userId", kebab: "user-id"},
+		{name: "HTTPServerName", snake: "http_server_name", camel: "httpServerName", kebab: "http-server-name"},
+		{name: "V2Name", snake: "v2_name", camel: "v2Name", kebab: "v2-name"},
+		{name: "Already_Snake", snake: "already_snake", camel: "alreadySnake", kebab: "already-snake"},
+	}
+
+	for _, tc := range tests {
+		snake, err := convertCase(tc.name, "snake")
+		must.NoError(t, err)
+		test.Eq(t, tc.snake, snake)
+
+		camel, err := convertCase(tc.name, "camel")
+		must.NoError(t, err)
+		test.Eq(t, tc.camel, camel)
+
+		kebab, err := convertCase(tc.name, "kebab")
+		must.NoError(t, err)
+		test.Eq(t, tc.kebab, kebab)
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	pairs, err := parseTag(`json:"a,omitempty" db:"b" x:"with \"quotes\""`)
+	must.NoError(t, err)
+	test.Eq(t, []tagPair{
+		{key: "json", value: "a,omitempty"},
+		{key: "db", value: "b"},
+		{key: "x", value: `with "quotes"`},
+	}, pairs)
+	test.Eq(t, `json:"a,omitempty" db:"b" x:"with \"quotes\""`, formatTag(pairs))
+
+	_, err = parseTag(`json:a`)
+	test.Error(t, err)
+}
+
+func TestGenerate_Add(t *testing.T) {
+	src := `package foo
+
+type User str<|>uct {
+	ID, OrgID int
+	// Name is the user's name.
+	Name     string ` + "`json:\"full_name\" db:\"name\"`" + ` // trailing comment
+	Ignored  bool   ` + "`json:\"-\"`" + `
+	internal string
+	Embedded
+}
+
+type Embedded struct{}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.StructTags{
+		Mode:    "add",
+		Keys:    []string{"json", "yaml"},
+		Case:    "snake",
+		KeyCase: map[string]string{"yaml": "camel"},
+		Options: map[string][]string{"json": {"omitempty"}},
+	})(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+type User struct {
+	ID    int `+"`json:\"id,omitempty\" yaml:\"id\"`"+`
+	OrgID int `+"`json:\"org_id,omitempty\" yaml:\"orgId\"`"+`
+	// Name is the user's name.
+	Name     string `+"`json:\"name,omitempty\" db:\"name\" yaml:\"name\"`"+` // trailing comment
+	Ignored  bool   `+"`json:\"-\" yaml:\"ignored\"`"+`
+	internal string
+	Embedded
+}
+
+type Embedded struct{}
+`, string(got))
+}
+
+func TestGenerate_RemoveFromUnexportedField(t *testing.T) {
+	src := `package foo
+
+type T str<|>uct {
+	A string ` + "`json:\"a\"`" + `
+	b string ` + "`json:\"b\"`" + `
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.StructTags{
+		Mode: "remove",
+		Keys: []string{"json"},
+	})(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+type T struct {
+	A string
+	b string
+}
+`, string(got))
+}
+
+func TestGenerate_RemoveSingleField(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	type T struct {
+		A string ` + "`json:\"a\" db:\"a\"`" + `
+		B<|> string ` + "`json:\"b\" db:\"b\"`" + `
+	}
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generator(config.StructTags{
+		Mode: "remove",
+		Keys: []string{"db"},
+	})(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(contents, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+func foo() {
+	type T struct {
+		A string `+"`json:\"a\" db:\"a\"`"+`
+		B string `+"`json:\"b\"`"+`
+	}
+}
+`, string(got))
+}
@@ -0,0 +1,69 @@
+package structtags
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tagPair struct {
+	key   string
+	value string
+}
+
+// parseTag splits a struct tag into its key:"value" pairs, keeping their order. It follows the same
+// rules as reflect.StructTag.Lookup.
+func parseTag(tag string) ([]tagPair, error) {
+	var pairs []tagPair
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return pairs, nil
+		}
+
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, fmt.Errorf("malformed struct tag: %q", tag)
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, fmt.Errorf("malformed struct tag: %q", tag)
+		}
+
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed struct tag: %w", err)
+		}
+		tag = tag[i+1:]
+
+		pairs = append(pairs, tagPair{key: key, value: value})
+	}
+}
+
+func formatTag(pairs []tagPair) string {
+	parts := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		parts = append(parts, p.key+":"+strconv.Quote(p.value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// tagLiteral returns the Go literal for the given tag, preferring a raw string.
+func tagLiteral(tag string) string {
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
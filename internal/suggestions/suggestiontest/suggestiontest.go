@@ -0,0 +1,102 @@
+// Package suggestiontest contains helpers for running suggestors against real packages written to
+// a temporary directory.
+package suggestiontest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/config"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/loader"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/shoenig/test/must"
+)
+
+// Cursor marks the cursor position in a test source. It is removed before the source is written to
+// disk.
+const Cursor = "<|>"
+
+// SelectionStart and SelectionEnd mark a selection in a test source instead of a cursor. They are
+// removed before the source is written to disk.
+const (
+	SelectionStart = "<["
+	SelectionEnd   = "]>"
+)
+
+// Load writes src to main.go in a fresh module along with any extra files (keyed by name relative
+// to the module root) and returns a loader whose cursor is at the position of Cursor in src. If src
+// marks a selection with SelectionStart and SelectionEnd instead, the loader has that selection and
+// the returned offset is its start.
+func Load(
+	t *testing.T,
+	src string,
+	extra map[string]string,
+) (*loader.Loader, file.Contents, int) {
+	t.Helper()
+	return LoadAt(t, "main.go", src, extra)
+}
+
+// LoadAt is like Load, but writes src to the given name instead of main.go.
+func LoadAt(
+	t *testing.T,
+	name string,
+	src string,
+	extra map[string]string,
+) (*loader.Loader, file.Contents, int) {
+	t.Helper()
+	return LoadWithOptions(t, name, src, extra, loader.Options{Build: config.Default().Build})
+}
+
+// LoadWithOptions is like LoadAt, but creates the loader with the given options.
+func LoadWithOptions(
+	t *testing.T,
+	name string,
+	src string,
+	extra map[string]string,
+	opts loader.Options,
+) (*loader.Loader, file.Contents, int) {
+	t.Helper()
+
+	logging.InitLogger(io.Discard)
+
+	var start, end int
+	if strings.Contains(src, SelectionStart) {
+		start = strings.Index(src, SelectionStart)
+		src = strings.Replace(src, SelectionStart, "", 1)
+		end = strings.Index(src, SelectionEnd)
+		must.Greater(t, start, end)
+		src = strings.Replace(src, SelectionEnd, "", 1)
+	} else {
+		start = strings.Index(src, Cursor)
+		must.NonNegative(t, start)
+		src = strings.Replace(src, Cursor, "", 1)
+		end = start
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/test\n\ngo 1.21\n")
+	for name, contents := range extra {
+		writeFile(t, filepath.Join(dir, name), contents)
+	}
+
+	path := filepath.Join(dir, name)
+	writeFile(t, path, src)
+
+	contents := file.Contents{
+		AbsPath:  path,
+		Contents: []byte(src),
+	}
+
+	return loader.NewSelection(contents, start, end, opts), contents, start
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+
+	must.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	must.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+}
@@ -0,0 +1,430 @@
+package tabletest
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/linewriter"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+)
+
+// Generate appends a table-driven test for the function declaration under the cursor to the
+// sibling _test.go file, creating it if it doesn't exist.
+func Generate(
+	l suggestions.PackageLoader,
+	contents file.Contents,
+	offset int,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "tabletest"})
+
+	if strings.HasSuffix(contents.AbsPath, "_test.go") {
+		return nil, nil
+	}
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var fnDecl *ast.FuncDecl
+	for _, n := range f.ASTPath {
+		if fd, ok := n.(*ast.FuncDecl); ok {
+			fnDecl = fd
+			break
+		}
+	}
+
+	// Only trigger on the function's signature; the body belongs to the other suggestors.
+	if fnDecl == nil || (fnDecl.Body != nil && f.Pos > fnDecl.Body.Lbrace) {
+		e.Debug("cursor is not on a function signature")
+		return nil, nil
+	}
+
+	if fnDecl.Name.Name == "_" || (fnDecl.Name.Name == "init" && fnDecl.Recv == nil) {
+		return nil, nil
+	}
+
+	pkg, err := l.LoadPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := pkg.TypesInfo.Defs[fnDecl.Name].(*types.Func)
+	if !ok {
+		return nil, errors.New("no type info for function")
+	}
+	sig := fn.Type().(*types.Signature)
+
+	if sig.TypeParams().Len() > 0 || sig.RecvTypeParams().Len() > 0 {
+		e.Debug("generic functions are not supported")
+		return nil, nil
+	}
+
+	testPath := strings.TrimSuffix(contents.AbsPath, ".go") + "_test.go"
+	tf, err := readTestFile(testPath)
+	if err != nil {
+		return nil, err
+	}
+
+	testPkgName := pkg.Name
+	if tf.ast != nil {
+		testPkgName = tf.ast.Name.Name
+	}
+	external := testPkgName != pkg.Name
+
+	g := &generator{
+		pkg:      pkg.Types,
+		file:     tf.ast,
+		external: external,
+		imports:  make(map[string]bool),
+	}
+	if g.file == nil {
+		g.file = &ast.File{}
+	}
+
+	var recvType *types.TypeName
+	if recv := sig.Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		named, ok := t.(*types.Named)
+		if !ok {
+			return nil, nil
+		}
+		recvType = named.Obj()
+	}
+
+	if external && (!fn.Exported() || (recvType != nil && !recvType.Exported())) {
+		e.Debug("unexported functions can't be tested from an external test package")
+		return nil, nil
+	}
+
+	testName := testFuncName(recvType, fn)
+	if tf.declares(testName) {
+		e.WithField("test", testName).Debug("test already exists")
+		return nil, nil
+	}
+
+	w := &linewriter.Writer{}
+	g.writeTest(w, testName, recvType, sig, fn)
+	testLines := w.TakeLines()
+
+	var paths []string
+	for p := range g.imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	if tf.ast == nil {
+		w := &linewriter.Writer{}
+		w.WriteLinef("package %s", testPkgName)
+		w.WriteLinef("")
+		specs := make([]string, 0, len(paths))
+		for _, p := range paths {
+			specs = append(specs, strconv.Quote(p))
+		}
+		for _, ln := range asthelper.ImportDecl(specs...) {
+			w.WriteLinef("%s", ln)
+		}
+		w.WriteLinef("")
+
+		return []file.Replacement{{
+			AbsPath: testPath,
+			Range:   file.Range{Start: file.Position{Line: 1, Col: 1}, Stop: file.Position{Line: 1, Col: 1}},
+			Lines:   append(w.TakeLines(), testLines...),
+		}}, nil
+	}
+
+	// Insert at the end of the last line rather than after the trailing newline; editors usually
+	// don't have a line to put the cursor on after it.
+	end := len(tf.src)
+	if end > 0 && tf.src[end-1] == '\n' {
+		end--
+	}
+	endPos := asthelper.PositionFor(tf.fset, tf.tokFile.Pos(end))
+
+	rs := []file.Replacement{{
+		AbsPath: testPath,
+		Range:   file.Range{Start: endPos, Stop: endPos},
+		Lines:   append([]string{"", ""}, testLines...),
+	}}
+
+	if r, ok := asthelper.ImportReplacement(tf.fset, tf.ast, paths...); ok {
+		r.AbsPath = testPath
+		rs = append(rs, r)
+	}
+
+	return rs, nil
+}
+
+type testFile struct {
+	src     []byte
+	fset    *token.FileSet
+	tokFile *token.File
+	ast     *ast.File
+}
+
+func readTestFile(path string) (testFile, error) {
+	src, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return testFile{}, nil
+	}
+	if err != nil {
+		return testFile{}, err
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.AllErrors|parser.ParseComments)
+	if f == nil {
+		return testFile{}, err
+	}
+
+	return testFile{
+		src:     src,
+		fset:    fset,
+		tokFile: fset.File(f.Pos()),
+		ast:     f,
+	}, nil
+}
+
+func (tf testFile) declares(name string) bool {
+	if tf.ast == nil {
+		return false
+	}
+
+	for _, d := range tf.ast.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func testFuncName(recvType *types.TypeName, fn *types.Func) string {
+	base := fn.Name()
+	if recvType != nil {
+		base = recvType.Name() + "_" + base
+	}
+
+	// Test functions must not continue with a lowercase letter after "Test", and TestMain is taken
+	// by the function that runs the tests.
+	if unicode.IsLower([]rune(base)[0]) || base == "Main" {
+		return "Test_" + base
+	}
+	return "Test" + base
+}
+
+type generator struct {
+	pkg *types.Package
+	// file is the test file the test goes in, which is empty if it doesn't exist yet.
+	file     *ast.File
+	external bool
+	// imports are the import paths that the test needs and file doesn't import.
+	imports map[string]bool
+}
+
+// qualifier returns the name the test file refers to p by, which is the name it's imported as if
+// the file already imports it.
+func (g *generator) qualifier(p *types.Package) string {
+	if p == g.pkg && !g.external {
+		return ""
+	}
+	for _, imp := range g.file.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || path != p.Path() {
+			continue
+		}
+		switch {
+		case imp.Name == nil:
+			return p.Name()
+		case imp.Name.Name == ".":
+			return ""
+		case imp.Name.Name != "_":
+			return imp.Name.Name
+		}
+	}
+	g.imports[p.Path()] = true
+	return p.Name()
+}
+
+// qualified returns how the test file refers to name from the standard library package with the
+// given import path.
+func (g *generator) qualified(path, name string) string {
+	s, needImport := asthelper.Qualified(g.file, path, name)
+	if needImport {
+		g.imports[path] = true
+	}
+	return s
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+type field struct {
+	name string
+	typ  string
+}
+
+type result struct {
+	got  string
+	want string
+	typ  types.Type
+}
+
+func (g *generator) writeTest(
+	w *linewriter.Writer,
+	testName string,
+	recvType *types.TypeName,
+	sig *types.Signature,
+	fn *types.Func,
+) {
+	used := map[string]bool{"name": true, "wantErr": true}
+	fields := []field{{name: "name", typ: "string"}}
+
+	var args []string
+	for i := 0; i < sig.Params().Len(); i++ {
+		v := sig.Params().At(i)
+
+		name := v.Name()
+		if name == "" || name == "_" {
+			name = "arg" + strconv.Itoa(i)
+		}
+		for used[name] || strings.HasPrefix(name, "want") {
+			name += "Arg"
+		}
+		used[name] = true
+
+		fields = append(fields, field{name: name, typ: g.typeString(v.Type())})
+
+		arg := "tt." + name
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			arg += "..."
+		}
+		args = append(args, arg)
+	}
+
+	nResults := sig.Results().Len()
+	returnsErr := nResults > 0 && isErrorType(sig.Results().At(nResults-1).Type())
+
+	var results []result
+	for i := 0; i < nResults; i++ {
+		if returnsErr && i == nResults-1 {
+			break
+		}
+
+		suffix := ""
+		if len(results) > 0 {
+			suffix = strconv.Itoa(len(results))
+		}
+		r := result{
+			got:  "got" + suffix,
+			want: "want" + suffix,
+			typ:  sig.Results().At(i).Type(),
+		}
+		results = append(results, r)
+		fields = append(fields, field{name: r.want, typ: g.typeString(r.typ)})
+	}
+	if returnsErr {
+		fields = append(fields, field{name: "wantErr", typ: "bool"})
+	}
+
+	maxNameLen := 0
+	for _, fld := range fields {
+		maxNameLen = max(maxNameLen, len(fld.name))
+	}
+
+	var recvName string
+	callee := fn.Name()
+	if recvType != nil {
+		recvName = sig.Recv().Name()
+		if recvName == "" || recvName == "_" || recvName == "t" || recvName == "tt" {
+			recvName = "recv"
+		}
+		callee = recvName + "." + callee
+	} else if g.external {
+		callee = g.qualifier(g.pkg) + "." + callee
+	}
+
+	testingT := g.qualified("testing", "T")
+
+	w.WriteLinef("func %s(t *%s) {", testName, testingT)
+	w.WriteLinef("\ttests := []struct {")
+	for _, fld := range fields {
+		w.WriteLinef("\t\t%s %s%s", fld.name, strings.Repeat(" ", maxNameLen-len(fld.name)), fld.typ)
+	}
+	w.WriteLinef("\t}{")
+	w.WriteLinef("\t\t// TODO: Add test cases.")
+	w.WriteLinef("\t}")
+	w.WriteLinef("")
+	w.WriteLinef("\tfor _, tt := range tests {")
+	w.WriteLinef("\t\tt.Run(tt.name, func(t *%s) {", testingT)
+
+	if recvType != nil {
+		w.WriteLinef("\t\t\tvar %s %s", recvName, g.typeString(recvType.Type()))
+	}
+
+	var lhs []string
+	for _, r := range results {
+		lhs = append(lhs, r.got)
+	}
+	if returnsErr {
+		lhs = append(lhs, "err")
+	}
+
+	call := fmt.Sprintf("%s(%s)", callee, strings.Join(args, ", "))
+	if len(lhs) == 0 {
+		w.WriteLinef("\t\t\t%s", call)
+	} else {
+		w.WriteLinef("\t\t\t%s := %s", strings.Join(lhs, ", "), call)
+	}
+
+	if returnsErr {
+		w.WriteLinef("\t\t\tif (err != nil) != tt.wantErr {")
+		w.WriteLinef("\t\t\t\tt.Fatalf(\"%s() error = %%v, wantErr %%v\", err, tt.wantErr)", callee)
+		w.WriteLinef("\t\t\t}")
+	}
+
+	for _, r := range results {
+		if _, ok := r.typ.Underlying().(*types.Basic); ok {
+			w.WriteLinef("\t\t\tif %s != tt.%s {", r.got, r.want)
+		} else {
+			w.WriteLinef("\t\t\tif !%s(%s, tt.%s) {", g.qualified("reflect", "DeepEqual"), r.got, r.want)
+		}
+		w.WriteLinef(
+			"\t\t\t\tt.Errorf(\"%s() %s = %%v, want %%v\", %s, tt.%s)",
+			callee,
+			r.got,
+			r.got,
+			r.want,
+		)
+		w.WriteLinef("\t\t\t}")
+	}
+
+	w.WriteLinef("\t\t})")
+	w.WriteLinef("\t}")
+	w.WriteLinef("}")
+}
+
+func isErrorType(typ types.Type) bool {
+	n, ok := typ.(*types.Named)
+	if !ok {
+		return false
+	}
+
+	return n != nil && n.Obj().Pkg() == nil && n.Obj().Name() == "error"
+}
@@ -0,0 +1,200 @@
+package tabletest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerate_NewFile(t *testing.T) {
+	src := `package foo
+
+type Server struct{}
+
+func (s *Server) Hand<|>le(name string, ids ...int) ([]string, int, error) {
+	return nil, 0, nil
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	must.SliceLen(t, 1, rs)
+
+	test.Eq(t, filepath.Join(filepath.Dir(contents.AbsPath), "main_test.go"), rs[0].AbsPath)
+	got, err := file.Apply(file.Contents{}, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServer_Handle(t *testing.T) {
+	tests := []struct {
+		name    string
+		nameArg string
+		ids     []int
+		want    []string
+		want1   int
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Server
+			got, got1, err := s.Handle(tt.nameArg, tt.ids...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("s.Handle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("s.Handle() got = %v, want %v", got, tt.want)
+			}
+			if got1 != tt.want1 {
+				t.Errorf("s.Handle() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}`, string(got))
+}
+
+func TestGenerate_ExistingExternalFile(t *testing.T) {
+	src := `package foo
+
+func <|>Double(x int) int {
+	return 2 * x
+}
+`
+
+	existing := `package foo_test
+
+import "fmt"
+
+var _ = fmt.Sprint
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, map[string]string{"main_test.go": existing})
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(file.Contents{Contents: []byte(existing)}, rs)
+	must.NoError(t, err)
+	test.Eq(t, `package foo_test
+
+import (
+	"fmt"
+	"testing"
+
+	"example.com/test"
+)
+
+var _ = fmt.Sprint
+
+func TestDouble(t *testing.T) {
+	tests := []struct {
+		name string
+		x    int
+		want int
+	}{
+		// TODO: Add test cases.
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := foo.Double(tt.x)
+			if got != tt.want {
+				t.Errorf("foo.Double() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_CursorInBody(t *testing.T) {
+	src := `package foo
+
+func Double(x int) int {
+	return <|>2 * x
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+}
+
+func TestGenerate_ImportAliases(t *testing.T) {
+	src := `package foo
+
+type Point struct{ X, Y int }
+
+func <|>Mirror(p Point) Point {
+	return Point{X: -p.X, Y: p.Y}
+}
+`
+
+	existing := `package foo_test
+
+import (
+	r "reflect"
+	tst "testing"
+
+	f "example.com/test"
+)
+
+var _ = r.DeepEqual
+var _ tst.TB
+var _ f.Point
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, map[string]string{"main_test.go": existing})
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+
+	got, err := file.Apply(file.Contents{Contents: []byte(existing)}, rs)
+	must.NoError(t, err)
+	test.Eq(t, existing+`
+func TestMirror(t *tst.T) {
+	tests := []struct {
+		name string
+		p    f.Point
+		want f.Point
+	}{
+		// TODO: Add test cases.
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *tst.T) {
+			got := f.Mirror(tt.p)
+			if !r.DeepEqual(got, tt.want) {
+				t.Errorf("f.Mirror() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+`, string(got))
+}
+
+func TestGenerate_Main(t *testing.T) {
+	src := `package foo
+
+func <|>Main() {}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	must.SliceLen(t, 1, rs)
+	got, err := file.Apply(file.Contents{}, rs)
+	must.NoError(t, err)
+	test.StrContains(t, string(got), "func Test_Main(t *testing.T) {")
+}
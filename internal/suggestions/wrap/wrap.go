@@ -0,0 +1,248 @@
+package wrap
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/cszczepaniak/go-tools/internal/asthelper"
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/linewriter"
+	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/suggestions"
+)
+
+// Generate puts the elements of the argument list, parameter or result list, or composite literal
+// under the cursor on their own lines, or joins them back onto one line if they're already split.
+func Generate(
+	l suggestions.FileParser,
+	contents file.Contents,
+	offset int,
+) ([]file.Replacement, error) {
+	e := logging.WithFields(map[string]any{"handler": "wrap"})
+
+	f, err := l.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	lst, ok := findList(f.ASTPath, f.Pos)
+	if !ok || len(lst.elems) == 0 {
+		e.Debug("cursor is not in a list")
+		return nil, nil
+	}
+
+	tokFile := f.Fset.File(lst.open)
+	elems := collectElements(tokFile, contents, f.File.Comments, lst)
+
+	// Elements can span lines themselves (function literals, for example), so whether the list is
+	// split depends on whether its first element starts on a line of its own.
+	w := linewriter.New(contents, tokFile.Offset(lst.open))
+	if tokFile.Line(lst.open) != tokFile.Line(lst.elems[0].Pos()) {
+		if !joinList(w, lst, elems) {
+			e.Debug("list has a comment that can't be put on one line")
+			return nil, nil
+		}
+	} else {
+		splitList(w, lst, elems)
+	}
+
+	return []file.Replacement{{
+		Range: file.Range{
+			Start: asthelper.PositionFor(f.Fset, lst.open),
+			Stop:  asthelper.PositionFor(f.Fset, lst.close+1),
+		},
+		Lines: w.TakeLines(),
+	}}, nil
+}
+
+// list is a bracketed, comma-separated list of nodes.
+type list struct {
+	open, close token.Pos
+	// openTok and closeTok are the brackets as they appear in the source.
+	openTok, closeTok string
+	elems             []ast.Node
+	// ellipsis is the position of the ... after the last element of a variadic call.
+	ellipsis token.Pos
+}
+
+// findList returns the innermost list whose brackets contain pos.
+func findList(path []ast.Node, pos token.Pos) (list, bool) {
+	for i, n := range path {
+		var lst list
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			lst = list{open: n.Lparen, close: n.Rparen, openTok: "(", closeTok: ")", ellipsis: n.Ellipsis}
+			for _, a := range n.Args {
+				lst.elems = append(lst.elems, a)
+			}
+		case *ast.CompositeLit:
+			lst = list{open: n.Lbrace, close: n.Rbrace, openTok: "{", closeTok: "}"}
+			for _, elt := range n.Elts {
+				lst.elems = append(lst.elems, elt)
+			}
+		case *ast.FieldList:
+			if i+1 >= len(path) || !n.Opening.IsValid() {
+				continue
+			}
+			// Only parameter and result lists; struct fields and interface methods aren't comma
+			// separated. The path skips the FuncType of a FuncDecl.
+			var ft *ast.FuncType
+			switch p := path[i+1].(type) {
+			case *ast.FuncType:
+				ft = p
+			case *ast.FuncDecl:
+				ft = p.Type
+			}
+			if ft == nil || (n != ft.Params && n != ft.Results) {
+				continue
+			}
+			lst = list{open: n.Opening, close: n.Closing, openTok: "(", closeTok: ")"}
+			for _, fld := range n.List {
+				lst.elems = append(lst.elems, fld)
+			}
+		default:
+			continue
+		}
+
+		if lst.open <= pos && pos <= lst.close {
+			return lst, true
+		}
+	}
+
+	return list{}, false
+}
+
+type element struct {
+	text string
+	// leading are the comments on their own lines before the element.
+	leading []string
+	// trailing are the comments after the element on the same line.
+	trailing []string
+}
+
+func collectElements(
+	tokFile *token.File,
+	contents file.Contents,
+	comments []*ast.CommentGroup,
+	lst list,
+) []element {
+	elems := make([]element, len(lst.elems))
+	for i, n := range lst.elems {
+		end := n.End()
+		if i == len(lst.elems)-1 && lst.ellipsis.IsValid() {
+			end = lst.ellipsis + token.Pos(len("..."))
+		}
+		elems[i].text = string(contents.BytesInRange(tokFile.Offset(n.Pos()), tokFile.Offset(end)))
+	}
+
+	// Any comments after the last element on their own lines end up as leading comments of this
+	// sentinel, which is written just before the closing bracket.
+	elems = append(elems, element{})
+
+	for _, cg := range comments {
+		if cg.Pos() < lst.open || cg.End() > lst.close {
+			continue
+		}
+
+		for _, c := range cg.List {
+			idx := 0
+			for idx < len(lst.elems) && lst.elems[idx].End() <= c.Pos() {
+				idx++
+			}
+
+			if idx < len(lst.elems) && lst.elems[idx].Pos() < c.Pos() {
+				// The comment is inside of an element, so it's already part of its text.
+				continue
+			}
+
+			if idx > 0 && tokFile.Line(lst.elems[idx-1].End()) == tokFile.Line(c.Pos()) {
+				elems[idx-1].trailing = append(elems[idx-1].trailing, c.Text)
+			} else {
+				elems[idx].leading = append(elems[idx].leading, c.Text)
+			}
+		}
+	}
+
+	return elems
+}
+
+func splitList(w *linewriter.Writer, lst list, elems []element) {
+	outer := w.Indentation()
+	w.WriteLinef("%s", lst.openTok)
+	w.Indent()
+	for i, el := range elems {
+		for _, c := range el.leading {
+			w.WriteLinef("%s", c)
+		}
+
+		if i == len(elems)-1 {
+			break
+		}
+
+		// The lines of an element after its first one move in by the level the element does.
+		ln := shiftIndent(el.text, outer, w.Indentation()) + ","
+		if len(el.trailing) > 0 {
+			ln += " " + strings.Join(el.trailing, " ")
+		}
+		w.WriteLinef("%s", ln)
+	}
+	w.Dedent()
+	w.WriteLinef("%s", lst.closeTok)
+}
+
+// joinList writes the list on one line. Line comments are turned into block comments; if that's not
+// possible, joinList returns false.
+func joinList(w *linewriter.Writer, lst list, elems []element) bool {
+	parts := make([]string, 0, len(elems))
+	for _, el := range elems {
+		var words []string
+		for _, c := range el.leading {
+			bc, ok := asthelper.BlockComment(c)
+			if !ok {
+				return false
+			}
+			words = append(words, bc)
+		}
+
+		if el.text != "" {
+			words = append(words, shiftIndent(el.text, w.Indentation()+"\t", w.Indentation()))
+		}
+
+		for _, c := range el.trailing {
+			bc, ok := asthelper.BlockComment(c)
+			if !ok {
+				return false
+			}
+			words = append(words, bc)
+		}
+
+		parts = append(parts, strings.Join(words, " "))
+	}
+
+	// The last element is the sentinel, which only carries comments and doesn't get a comma.
+	joined := strings.Join(parts[:len(parts)-1], ", ")
+	if dangling := parts[len(parts)-1]; dangling != "" {
+		joined += " " + dangling
+	}
+
+	w.Write([]byte(lst.openTok + joined + lst.closeTok))
+	w.Flush()
+	return true
+}
+
+// shiftIndent swaps the indentation from for to at the start of each line of text after the first.
+// Text with a raw string is left alone, since its lines may be part of the string.
+func shiftIndent(text, from, to string) string {
+	if strings.Contains(text, "`") {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = to + strings.TrimPrefix(lines[i], from)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,174 @@
+package wrap
+
+import (
+	"testing"
+
+	"github.com/cszczepaniak/go-tools/internal/file"
+	"github.com/cszczepaniak/go-tools/internal/suggestions/suggestiontest"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{{
+		name: "split call arguments",
+		src: `package foo
+
+func foo() {
+	if true {
+		bar(a, <|>b, c...)
+	}
+}
+`,
+		want: `package foo
+
+func foo() {
+	if true {
+		bar(
+			a,
+			b,
+			c...,
+		)
+	}
+}
+`,
+	}, {
+		name: "join call arguments with comments",
+		src: `package foo
+
+func foo() {
+	bar(
+		// leading
+		a, // trailing
+		b<|>,
+		/* dangling */
+	)
+}
+`,
+		want: `package foo
+
+func foo() {
+	bar(/* leading */ a /* trailing */, b /* dangling */)
+}
+`,
+	}, {
+		name: "split params",
+		src: `package foo
+
+func foo(a, b int, <|>c string) (int, error) {
+	return 0, nil
+}
+`,
+		want: `package foo
+
+func foo(
+	a, b int,
+	c string,
+) (int, error) {
+	return 0, nil
+}
+`,
+	}, {
+		name: "join results",
+		src: `package foo
+
+func foo() (
+	int,
+	error<|>,
+) {
+	return 0, nil
+}
+`,
+		want: `package foo
+
+func foo() (int, error) {
+	return 0, nil
+}
+`,
+	}, {
+		name: "split composite literal, innermost first",
+		src: `package foo
+
+var x = []T{{A: 1, <|>B: 2}, {A: 3}}
+`,
+		want: `package foo
+
+var x = []T{{
+	A: 1,
+	B: 2,
+}, {A: 3}}
+`,
+	}, {
+		name: "split call with a function literal",
+		src: `package foo
+
+func foo() {
+	t.Run(<|>"name", func(t *testing.T) {
+		t.Log("x")
+	})
+}
+`,
+		want: `package foo
+
+func foo() {
+	t.Run(
+		"name",
+		func(t *testing.T) {
+			t.Log("x")
+		},
+	)
+}
+`,
+	}, {
+		name: "join call with a function literal",
+		src: `package foo
+
+func foo() {
+	t.Run(
+		<|>"name",
+		func(t *testing.T) {
+			t.Log("x")
+		},
+	)
+}
+`,
+		want: `package foo
+
+func foo() {
+	t.Run("name", func(t *testing.T) {
+		t.Log("x")
+	})
+}
+`,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, contents, offset := suggestiontest.Load(t, tc.src, nil)
+			rs, err := Generate(l, contents, offset)
+			must.NoError(t, err)
+			got, err := file.Apply(contents, rs)
+			must.NoError(t, err)
+			test.Eq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestGenerate_NotInList(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	x := <|>1
+	_ = x
+}
+`
+
+	l, contents, offset := suggestiontest.Load(t, src, nil)
+	rs, err := Generate(l, contents, offset)
+	must.NoError(t, err)
+	test.SliceEmpty(t, rs)
+}
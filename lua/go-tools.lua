@@ -2,8 +2,13 @@ local M = {}
 
 function M.init()
 	local tools = require("go-tools.go-tools")
-	vim.api.nvim_create_user_command("GoToolsOmni", tools.run, {})
+	vim.api.nvim_create_user_command("GoToolsOmni", function(opts)
+		tools.run({}, opts.range > 0)
+	end, { range = true })
+	vim.api.nvim_create_user_command("GoToolsStructTags", tools.struct_tags, { nargs = "*" })
+	vim.api.nvim_create_user_command("GoToolsExtractVar", tools.extract_var, { range = true })
 	vim.keymap.set("n", "<leader>go", "<cmd>GoToolsOmni<CR>", { desc = "[G]o tools [o]mni function" })
+	vim.keymap.set("x", "<leader>go", ":GoToolsOmni<CR>", { desc = "[G]o tools [o]mni function on the selection" })
 end
 
 return M
@@ -1,18 +1,82 @@
 local M = {}
 
-function M.run()
+-- selection returns the 0-indexed byte offsets of the start and the end (exclusive) of the last
+-- visual selection.
+local function selection()
+	local s = vim.fn.getpos("'<")
+	local e = vim.fn.getpos("'>")
+
+	-- In linewise mode, the column of the end mark is past the end of the line.
+	local last_line = vim.fn.getline(e[2])
+	local end_col = math.min(e[3], #last_line)
+
+	local start = vim.fn.line2byte(s[2]) + s[3] - 2
+	local stop = vim.fn.line2byte(e[2]) + end_col - 1
+	return start, stop
+end
+
+-- jump moves the cursor to where the user is likely to type next in the current buffer: the first
+-- placeholder, which is selected so that typing replaces it, or else the cursor position the
+-- replacements ask for. Positions are in the buffer after all of the replacements are applied.
+local function jump(output)
+	local function before(a, b)
+		return a.ln < b.ln or (a.ln == b.ln and a.col < b.col)
+	end
+
+	local first, cursor
+	for _, repl in ipairs(output) do
+		if repl.path == nil then
+			for _, ph in ipairs(repl.phs or {}) do
+				if first == nil or ph.idx < first.idx or (ph.idx == first.idx and before(ph.rng.start, first.rng.start)) then
+					first = ph
+				end
+			end
+			cursor = cursor or repl.cur
+		end
+	end
+
+	if first == nil then
+		if cursor ~= nil then
+			vim.api.nvim_win_set_cursor(0, { cursor.ln, cursor.col - 1 })
+		end
+		return
+	end
+
+	local start, stop = first.rng.start, first.rng.stop
+	vim.api.nvim_win_set_cursor(0, { start.ln, start.col - 1 })
+	if stop.ln == start.ln and stop.col == start.col then
+		return
+	end
+
+	-- Visually select the placeholder, then switch to select mode.
+	vim.cmd("normal! v")
+	vim.api.nvim_win_set_cursor(0, { stop.ln, stop.col - 2 })
+	vim.api.nvim_feedkeys(vim.keycode("<C-g>"), "n", false)
+end
+
+-- run invokes go-tools at the cursor, or on the last visual selection if use_selection is set.
+-- extra_args are passed to go-tools before the position.
+function M.run(extra_args, use_selection)
 	if vim.bo.filetype ~= "go" then
 		return
 	end
 
-	-- cursor_bytes is 1-indexed, but the Go side will want it to be 0-indexed.
-	local pos = vim.fn.wordcount().cursor_bytes - 1
 	local file = vim.fn.expand("%")
+	local position
+	if use_selection then
+		local start, stop = selection()
+		position = file .. "," .. tostring(start) .. "-" .. tostring(stop)
+	else
+		-- cursor_bytes is 1-indexed, but the Go side will want it to be 0-indexed.
+		local pos = vim.fn.wordcount().cursor_bytes - 1
+		position = file .. "," .. tostring(pos)
+	end
 
-	local res = vim.system({
-		"go-tools",
-		file .. "," .. tostring(pos),
-	}, {
+	local cmd = { "go-tools" }
+	vim.list_extend(cmd, extra_args or {})
+	table.insert(cmd, position)
+
+	local res = vim.system(cmd, {
 		text = true,
 		stdin = vim.api.nvim_buf_get_lines(0, 0, -1, false),
 	}):wait()
@@ -32,14 +96,52 @@ function M.run()
 
 	local output = vim.json.decode(res.stdout)
 
-	vim.api.nvim_buf_set_text(
-		0,
-		output.rng.start.ln - 1,
-		output.rng.start.col - 1,
-		output.rng.stop.ln - 1,
-		output.rng.stop.col - 1,
-		output.lns
-	)
+	-- Replacements come sorted from the bottom of each file to the top, so applying them in order
+	-- keeps the positions of the remaining ones valid.
+	local edited = {}
+	for _, repl in ipairs(output) do
+		local buf = 0
+		if repl.path ~= nil then
+			buf = vim.fn.bufadd(repl.path)
+			vim.fn.bufload(buf)
+			vim.bo[buf].buflisted = true
+		end
+
+		vim.api.nvim_buf_set_text(
+			buf,
+			repl.rng.start.ln - 1,
+			repl.rng.start.col - 1,
+			repl.rng.stop.ln - 1,
+			repl.rng.stop.col - 1,
+			repl.lns
+		)
+
+		if buf ~= 0 and not edited[repl.path] then
+			edited[repl.path] = true
+			vim.notify("go-tools edited " .. repl.path, vim.log.levels.INFO, {})
+		end
+	end
+
+	jump(output)
+end
+
+-- struct_tags runs only the struct tag generator. opts.fargs may contain the mode (add, remove or
+-- align) followed by a comma-separated list of tag keys; both default to the config.
+function M.struct_tags(opts)
+	local args = { "-only", "structtags" }
+	if opts.fargs[1] ~= nil then
+		vim.list_extend(args, { "-tags-mode", opts.fargs[1] })
+	end
+	if opts.fargs[2] ~= nil then
+		vim.list_extend(args, { "-tags-keys", opts.fargs[2] })
+	end
+	M.run(args)
+end
+
+-- extract_var runs only the variable extractor, on the expression under the cursor or on the
+-- selected expression if opts has a range.
+function M.extract_var(opts)
+	M.run({ "-only", "extractvar" }, opts.range > 0)
 end
 
 return M
@@ -1,19 +1,45 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"runtime/trace"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cszczepaniak/go-tools/internal"
+	"github.com/cszczepaniak/go-tools/internal/config"
 	"github.com/cszczepaniak/go-tools/internal/file"
 	"github.com/cszczepaniak/go-tools/internal/logging"
+	"github.com/cszczepaniak/go-tools/internal/lsp"
 )
 
 func main() {
+	only := flag.String(
+		"only",
+		"",
+		"Comma-separated names of the suggestors to run. By default, every omni suggestor is tried.",
+	)
+	tagsMode := flag.String("tags-mode", "", "Overrides structTags.mode from the config.")
+	tagsKeys := flag.String("tags-keys", "", "Comma-separated tag keys. Overrides structTags.keys from the config.")
+	encodingName := flag.String(
+		"encoding",
+		"utf-8",
+		"What columns are counted in, for file:line:col input and for the output: utf-8 (bytes), utf-16 or utf-32 (runes).",
+	)
+	flag.Parse()
+
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
@@ -37,52 +63,339 @@ func main() {
 
 	logging.InitLogger(io.MultiWriter(os.Stderr, logFile))
 
+	enc, err := file.ParseColumnEncoding(*encodingName)
+	if err != nil {
+		logging.WithError(err).Fatal("invalid -encoding")
+	}
+
+	if flag.Arg(0) == "profile" {
+		profile(flag.Args()[1:], enc, *only, *tagsMode, *tagsKeys)
+		return
+	}
+
+	if flag.Arg(0) == "lsp" {
+		serveLSP(flag.Args()[1:])
+		return
+	}
+
 	fileContents, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		panic(err)
 	}
 
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		logging.Fatal("must provide one arg")
 	}
 
-	parts := strings.Split(os.Args[1], ",")
-	if len(parts) != 2 {
-		logging.Fatal("argument must be of the form: filename,byte_offset")
+	pos := parsePosition(flag.Arg(0))
+	contents := file.Contents{
+		AbsPath:  pos.absPath,
+		Contents: fileContents,
+	}
+	cfg := loadConfig(pos.absPath, *tagsMode, *tagsKeys)
+
+	start, end := pos.offsetsIn(contents, enc)
+	repl, err := internal.GenerateReplacements(
+		contents,
+		start,
+		end,
+		cfg,
+		splitNames(*only)...,
+	)
+	if err != nil {
+		logging.WithError(err).Fatal("error generating replacements")
 	}
 
-	filePath := parts[0]
-	byteOffsetStr := parts[1]
+	if len(repl) == 0 {
+		return
+	}
 
-	absPath, err := filepath.Abs(filePath)
+	err = convertColumns(repl, contents, enc)
 	if err != nil {
-		logging.WithError(err).Fatal("error getting absolute path of file")
+		logging.WithError(err).Fatal("error converting columns")
 	}
 
-	byteOffset, err := strconv.Atoi(byteOffsetStr)
+	err = json.NewEncoder(os.Stdout).Encode(repl)
 	if err != nil {
-		logging.WithError(err).Fatal("error converting line to int")
+		logging.WithError(err).Fatal("error encoding replacement to JSON")
 	}
+}
 
-	repl, err := internal.GenerateReplacements(
-		file.Contents{
-			AbsPath:  absPath,
-			Contents: fileContents,
-		},
-		byteOffset,
+// serveLSP runs the language server on stdin and stdout, either on its own or in front of gopls.
+func serveLSP(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	gopls := flags.String(
+		"gopls",
+		"",
+		"Path to gopls. If set, gopls is started with the remaining arguments, everything is forwarded to it, and our code actions are added to its own.",
 	)
+	flags.Parse(args)
+
+	var err error
+	if *gopls == "" {
+		err = lsp.Serve(os.Stdin, os.Stdout)
+	} else {
+		err = lsp.Proxy(os.Stdin, os.Stdout, exec.Command(*gopls, flags.Args()...))
+	}
 	if err != nil {
-		logging.WithError(err).Fatal("error generating replacements")
+		logging.WithError(err).Fatal("language server failed")
 	}
+}
 
-	if len(repl.Lines) == 0 {
-		return
+// profile runs the suggestors like a normal invocation, but on the file as it is on disk, and prints
+// where the time went instead of the replacements.
+func profile(args []string, enc file.ColumnEncoding, only, tagsMode, tagsKeys string) {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	traceOut := flags.String("trace", "", "Write a runtime/trace to this file.")
+	cpuOut := flags.String("cpuprofile", "", "Write a pprof CPU profile to this file.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: go-tools [flags] profile [-trace file] [-cpuprofile file] filename,byte_offset[-end]|filename:line:col[-line:col]")
+		flags.PrintDefaults()
 	}
+	flags.Parse(args)
 
-	err = json.NewEncoder(os.Stdout).Encode(repl)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	pos := parsePosition(flags.Arg(0))
+	cfg := loadConfig(pos.absPath, tagsMode, tagsKeys)
+
+	fileContents, err := os.ReadFile(pos.absPath)
+	if err != nil {
+		logging.WithError(err).Fatal("error reading file")
+	}
+	contents := file.Contents{
+		AbsPath:  pos.absPath,
+		Contents: fileContents,
+	}
+
+	if *traceOut != "" {
+		f, err := os.Create(*traceOut)
+		if err != nil {
+			logging.WithError(err).Fatal("error creating trace file")
+		}
+		defer f.Close()
+
+		err = trace.Start(f)
+		if err != nil {
+			logging.WithError(err).Fatal("error starting trace")
+		}
+		defer trace.Stop()
+	}
+
+	if *cpuOut != "" {
+		f, err := os.Create(*cpuOut)
+		if err != nil {
+			logging.WithError(err).Fatal("error creating CPU profile")
+		}
+		defer f.Close()
+
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			logging.WithError(err).Fatal("error starting CPU profile")
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	start, end := pos.offsetsIn(contents, enc)
+	rep, err := internal.Profile(
+		contents,
+		start,
+		end,
+		cfg,
+		splitNames(only)...,
+	)
+	if err != nil {
+		logging.WithError(err).Error("error generating replacements")
+	}
+
+	region := trace.StartRegion(context.Background(), "encode")
+	t0 := time.Now()
+	err = json.NewEncoder(io.Discard).Encode(rep.Replacements)
+	encode := time.Since(t0)
+	region.End()
 	if err != nil {
 		logging.WithError(err).Fatal("error encoding replacement to JSON")
 	}
+
+	err = rep.Write(os.Stdout, encode)
+	if err != nil {
+		logging.WithError(err).Fatal("error writing report")
+	}
+}
+
+var (
+	offsetArg  = regexp.MustCompile(`^(.+),(\d+)(?:-(\d+))?$`)
+	lineColArg = regexp.MustCompile(`^(.+):(\d+):(\d+)(?:-(\d+):(\d+))?$`)
+)
+
+// position is where the cursor or the selection is.
+type position struct {
+	absPath    string
+	start, end location
+}
+
+// location is either a byte offset or a 1-based line and column.
+type location struct {
+	offset  int
+	lineCol *file.Position
+}
+
+// parsePosition parses filename,byte_offset or filename:line:col. Either can be followed by -end
+// for a selection, e.g. main.go,10-42 or main.go:3:1-5:12.
+func parsePosition(arg string) position {
+	var pos position
+	var filePath string
+
+	atoi := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			logging.WithError(err).Fatal("error converting position to int")
+		}
+		return n
+	}
+
+	if m := offsetArg.FindStringSubmatch(arg); m != nil {
+		filePath = m[1]
+		pos.start = location{offset: atoi(m[2])}
+		pos.end = pos.start
+		if m[3] != "" {
+			pos.end = location{offset: atoi(m[3])}
+		}
+	} else if m := lineColArg.FindStringSubmatch(arg); m != nil {
+		filePath = m[1]
+		pos.start = location{lineCol: &file.Position{Line: atoi(m[2]), Col: atoi(m[3])}}
+		pos.end = pos.start
+		if m[4] != "" {
+			pos.end = location{lineCol: &file.Position{Line: atoi(m[4]), Col: atoi(m[5])}}
+		}
+	} else {
+		logging.Fatal("argument must be of the form: filename,byte_offset or filename:line:col")
+	}
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		logging.WithError(err).Fatal("error getting absolute path of file")
+	}
+	pos.absPath = absPath
+
+	return pos
+}
+
+// offsetsIn returns the byte offsets of the start and end of the position.
+func (p position) offsetsIn(contents file.Contents, enc file.ColumnEncoding) (int, int) {
+	return p.start.offsetIn(contents, enc), p.end.offsetIn(contents, enc)
+}
+
+func (l location) offsetIn(contents file.Contents, enc file.ColumnEncoding) int {
+	if l.lineCol == nil {
+		return l.offset
+	}
+
+	offset, err := contents.OffsetOf(*l.lineCol, enc)
+	if err != nil {
+		logging.WithError(err).Fatal("invalid position")
+	}
+	return offset
+}
+
+// convertColumns converts the byte columns of the replacements to enc. Replacements in other files
+// are converted using those files as they are on disk. Placeholders and cursors are converted using
+// the files as they are once the replacements are applied.
+func convertColumns(rs []file.Replacement, contents file.Contents, enc file.ColumnEncoding) error {
+	if enc == file.UTF8 {
+		return nil
+	}
+
+	byPath := make(map[string][]file.Replacement)
+	for _, r := range rs {
+		byPath[r.AbsPath] = append(byPath[r.AbsPath], r)
+	}
+
+	// The contents of each file before and after its replacements are applied.
+	type versions struct{ before, after file.Contents }
+	files := make(map[string]versions)
+	for path, frs := range byPath {
+		c := contents
+		if path != "" && path != contents.AbsPath {
+			bs, err := os.ReadFile(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			c = file.Contents{AbsPath: path, Contents: bs}
+		}
+
+		after, err := file.Apply(c, frs)
+		if err != nil {
+			return err
+		}
+		files[path] = versions{before: c, after: file.Contents{AbsPath: path, Contents: after}}
+	}
+
+	for i, r := range rs {
+		f := files[r.AbsPath]
+
+		rng, err := convertRange(f.before, r.Range, enc)
+		if err != nil {
+			return err
+		}
+		rs[i].Range = rng
+
+		for j, ph := range r.Placeholders {
+			rng, err := convertRange(f.after, ph.Range, enc)
+			if err != nil {
+				return err
+			}
+			rs[i].Placeholders[j].Range = rng
+		}
+
+		if r.Cursor != nil {
+			cur, err := f.after.ConvertPosition(*r.Cursor, enc)
+			if err != nil {
+				return err
+			}
+			rs[i].Cursor = &cur
+		}
+	}
+
+	return nil
+}
+
+func convertRange(c file.Contents, r file.Range, enc file.ColumnEncoding) (file.Range, error) {
+	start, err := c.ConvertPosition(r.Start, enc)
+	if err != nil {
+		return file.Range{}, err
+	}
+	stop, err := c.ConvertPosition(r.Stop, enc)
+	if err != nil {
+		return file.Range{}, err
+	}
+	return file.Range{Start: start, Stop: stop}, nil
+}
+
+func loadConfig(absPath, tagsMode, tagsKeys string) config.Config {
+	cfg, err := config.Load(absPath)
+	if err != nil {
+		logging.WithError(err).Fatal("error loading config")
+	}
+
+	if tagsMode != "" {
+		cfg.StructTags.Mode = tagsMode
+	}
+	if tagsKeys != "" {
+		cfg.StructTags.Keys = strings.Split(tagsKeys, ",")
+	}
+
+	return cfg
+}
+
+func splitNames(only string) []string {
+	if only == "" {
+		return nil
+	}
+	return strings.Split(only, ",")
 }
 
 func fooBar() (int, file.Range, error) {